@@ -6,6 +6,7 @@ import (
 	"fmt"
 	syslog "github.com/RackSec/srslog"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -83,7 +84,7 @@ func startCapture(cmd *cobra.Command, args []string) {
 
 	// Verify HTTP authentication mode
 	switch params.HTTPAuthMode {
-	case "ntlm", "basic":
+	case "ntlm", "basic", "registry":
 		// OK
 	case "":
 		// Default to NTLM if empty
@@ -93,6 +94,24 @@ func startCapture(cmd *cobra.Command, args []string) {
 		log.Fatalf("invalid HTTP authentication mode specified: %s", params.HTTPAuthMode)
 	}
 
+	// Verify WPAD authentication mode
+	switch params.WPADAuthMode {
+	case "ntlm", "basic":
+		// OK
+	case "none", "":
+		params.WPADAuthMode = ""
+	default:
+		log.Fatalf("invalid WPAD authentication mode specified: %s", params.WPADAuthMode)
+	}
+
+	// Verify RTSP authentication mode
+	switch params.RTSPAuthMode {
+	case "basic", "digest", "both":
+		// OK
+	default:
+		log.Fatalf("invalid RTSP authentication mode specified: %s", params.RTSPAuthMode)
+	}
+
 	// Configure output actions
 	rw := setupOutput(args)
 
@@ -113,6 +132,7 @@ func startCapture(cmd *cobra.Command, args []string) {
 
 	// LDAP/LDAPS
 	if _, enabled := protocols["ldap"]; enabled {
+		setupLDAPDirectory()
 		setupLDAP(rw)
 		setupLDAPS(rw)
 	}
@@ -123,6 +143,11 @@ func startCapture(cmd *cobra.Command, args []string) {
 		setupHTTPS(rw)
 	}
 
+	// HTTP proxy
+	if _, enabled := protocols["proxy"]; enabled {
+		setupProxy(rw)
+	}
+
 	// DNS
 	if _, enabled := protocols["dns"]; enabled {
 		setupDNS(rw)
@@ -131,6 +156,90 @@ func startCapture(cmd *cobra.Command, args []string) {
 	// FTP
 	if _, enabled := protocols["ftp"]; enabled {
 		setupFTP(rw)
+		setupFTPS(rw)
+	}
+
+	// SIP/SIPS
+	if _, enabled := protocols["sip"]; enabled {
+		setupSIP(rw)
+		setupSIPS(rw)
+	}
+
+	// IPMI
+	if _, enabled := protocols["ipmi"]; enabled {
+		setupIPMI(rw)
+	}
+
+	// SOCKS
+	if _, enabled := protocols["socks"]; enabled {
+		setupSOCKS(rw)
+	}
+
+	// LLMNR, NBT-NS, and mDNS responders
+	if _, enabled := protocols["llmnr"]; enabled {
+		setupLLMNR(rw)
+	}
+
+	if _, enabled := protocols["nbns"]; enabled {
+		setupNBNS(rw)
+	}
+
+	if _, enabled := protocols["mdns"]; enabled {
+		setupMDNS(rw)
+	}
+
+	// Oracle
+	if _, enabled := protocols["oracle"]; enabled {
+		setupOracle(rw)
+	}
+
+	// MQTT
+	if _, enabled := protocols["mqtt"]; enabled {
+		setupMQTT(rw)
+		setupMQTTS(rw)
+	}
+
+	// AMQP
+	if _, enabled := protocols["amqp"]; enabled {
+		setupAMQP(rw)
+		setupAMQPS(rw)
+	}
+
+	// RTSP
+	if _, enabled := protocols["rtsp"]; enabled {
+		setupRTSP(rw)
+	}
+
+	// XMPP
+	if _, enabled := protocols["xmpp"]; enabled {
+		setupXMPP(rw)
+	}
+
+	// IRC
+	if _, enabled := protocols["irc"]; enabled {
+		setupIRC(rw)
+		setupIRCS(rw)
+	}
+
+	// NNTP
+	if _, enabled := protocols["nntp"]; enabled {
+		setupNNTP(rw)
+		setupNNTPS(rw)
+	}
+
+	// rsync
+	if _, enabled := protocols["rsync"]; enabled {
+		setupRSYNC(rw)
+	}
+
+	// SNMP traps
+	if _, enabled := protocols["snmptrap"]; enabled {
+		setupSNMPTrap(rw)
+	}
+
+	// CLDAP
+	if _, enabled := protocols["cldap"]; enabled {
+		setupCLDAP(rw)
 	}
 
 	// Make sure at least one capture is running
@@ -415,6 +524,10 @@ func setupSSH(rw *flamingo.RecordWriter) {
 		sshConf.PrivateKey = sshHostKey
 		sshConf.BindPort = uint16(port)
 		sshConf.RecordWriter = rw
+		sshConf.Prompts = splitList(params.SSHPrompts)
+		sshConf.Shell = params.SSHShell
+		sshConf.ShellHostname = params.SSHShellHostname
+		sshConf.SessionTimeout = params.SSHSessionTimeout
 		if err := flamingo.SpawnSSH(sshConf); err != nil {
 			if params.DontIgnoreFailures {
 				log.Fatalf("failed to start ssh server %s:%d: %s", sshConf.BindHost, sshConf.BindPort, err)
@@ -440,6 +553,11 @@ func setupSNMP(rw *flamingo.RecordWriter) {
 		snmpConf := flamingo.NewConfSNMP()
 		snmpConf.BindPort = uint16(port)
 		snmpConf.RecordWriter = rw
+		if params.SNMPEngineID != "" {
+			snmpConf.EngineID = params.SNMPEngineID
+		}
+		snmpConf.EngineBoots = params.SNMPEngineBoots
+		snmpConf.EngineTime = params.SNMPEngineTime
 		if err := flamingo.SpawnSNMP(snmpConf); err != nil {
 			if params.DontIgnoreFailures {
 				log.Fatalf("failed to start snmp server %s:%d: %s", snmpConf.BindHost, snmpConf.BindPort, err)
@@ -453,6 +571,17 @@ func setupSNMP(rw *flamingo.RecordWriter) {
 	}
 }
 
+func setupLDAPDirectory() {
+	if params.LDAPDirectory == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(params.LDAPDirectory)
+	if err != nil {
+		log.Fatalf("failed to read ldap ldif %s: %s", params.LDAPDirectory, err)
+	}
+	params.LDAPDirectoryData = string(data)
+}
+
 func setupLDAP(rw *flamingo.RecordWriter) {
 
 	// Create a listener for each port
@@ -465,6 +594,12 @@ func setupLDAP(rw *flamingo.RecordWriter) {
 		ldapConf := flamingo.NewConfLDAP()
 		ldapConf.BindPort = uint16(port)
 		ldapConf.RecordWriter = rw
+		ldapConf.TLSCert = params.TLSCertData
+		ldapConf.TLSKey = params.TLSKeyData
+		ldapConf.TLSName = params.TLSName
+		ldapConf.BaseDN = params.LDAPBaseDN
+		ldapConf.Functional = params.LDAPFunctional
+		ldapConf.Directory = params.LDAPDirectoryData
 		if err := flamingo.SpawnLDAP(ldapConf); err != nil {
 			if params.DontIgnoreFailures {
 				log.Fatalf("failed to start ldap server %s:%d: %s", ldapConf.BindHost, ldapConf.BindPort, err)
@@ -494,6 +629,9 @@ func setupLDAPS(rw *flamingo.RecordWriter) {
 		ldapConf.TLSCert = params.TLSCertData
 		ldapConf.TLSKey = params.TLSKeyData
 		ldapConf.TLSName = params.TLSName
+		ldapConf.BaseDN = params.LDAPBaseDN
+		ldapConf.Functional = params.LDAPFunctional
+		ldapConf.Directory = params.LDAPDirectoryData
 		if err := flamingo.SpawnLDAP(ldapConf); err != nil {
 			if params.DontIgnoreFailures {
 				log.Fatalf("failed to start ldaps server %s:%d: %q", ldapConf.BindHost, ldapConf.BindPort, err)
@@ -521,6 +659,8 @@ func setupHTTP(rw *flamingo.RecordWriter) {
 		httpConf.RecordWriter = rw
 		httpConf.BasicRealm = params.HTTPBasicRealm
 		httpConf.AuthMode = params.HTTPAuthMode
+		httpConf.WPADProxy = params.WPADProxy
+		httpConf.WPADAuth = params.WPADAuthMode
 		if err := flamingo.SpawnHTTP(httpConf); err != nil {
 			if params.DontIgnoreFailures {
 				log.Fatalf("failed to start ldaps server %s:%d: %q", httpConf.BindHost, httpConf.BindPort, err)
@@ -552,6 +692,8 @@ func setupHTTPS(rw *flamingo.RecordWriter) {
 		httpConf.TLSKey = params.TLSKeyData
 		httpConf.TLSName = params.TLSName
 		httpConf.AuthMode = params.HTTPAuthMode
+		httpConf.WPADProxy = params.WPADProxy
+		httpConf.WPADAuth = params.WPADAuthMode
 		if err := flamingo.SpawnHTTP(httpConf); err != nil {
 			if params.DontIgnoreFailures {
 				log.Fatalf("failed to start ldaps server %s:%d: %q", httpConf.BindHost, httpConf.BindPort, err)
@@ -565,6 +707,33 @@ func setupHTTPS(rw *flamingo.RecordWriter) {
 	}
 }
 
+func setupProxy(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	proxyPorts, err := flamingo.CrackPorts(params.ProxyPorts)
+	if err != nil {
+		log.Fatalf("failed to process proxy ports %s: %s", params.ProxyPorts, err)
+	}
+
+	for _, port := range proxyPorts {
+		httpConf := flamingo.NewConfHTTP()
+		httpConf.BindPort = uint16(port)
+		httpConf.RecordWriter = rw
+		httpConf.BasicRealm = params.ProxyBasicRealm
+		httpConf.Proxy = true
+		if err := flamingo.SpawnHTTP(httpConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start proxy server %s:%d: %q", httpConf.BindHost, httpConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start proxy server %s:%d: %q", httpConf.BindHost, httpConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { httpConf.Shutdown() })
+	}
+}
+
 func setupDNS(rw *flamingo.RecordWriter) {
 
 	// Create a listener for each port
@@ -596,18 +765,21 @@ func setupFTP(rw *flamingo.RecordWriter) {
 	// Create a listner for each port
 	ftpPorts, err := flamingo.CrackPorts(params.FTPPorts)
 	if err != nil {
-		log.Fatal("failed to process ftp ports %s: %s", params.FTPPorts, err)
+		log.Fatalf("failed to process ftp ports %s: %s", params.FTPPorts, err)
 	}
 
 	for _, port := range ftpPorts {
 		ftpConf := flamingo.NewConfFTP()
 		ftpConf.BindPort = uint16(port)
 		ftpConf.RecordWriter = rw
+		ftpConf.TLSCert = params.TLSCertData
+		ftpConf.TLSKey = params.TLSKeyData
+		ftpConf.TLSName = params.TLSName
 		if err := flamingo.SpawnFTP(ftpConf); err != nil {
 			if params.DontIgnoreFailures {
-				log.Fatalf("failed to start dns server %s:%d: %q", ftpConf.BindHost, ftpConf.BindPort, err)
+				log.Fatalf("failed to start ftp server %s:%d: %q", ftpConf.BindHost, ftpConf.BindPort, err)
 			} else {
-				log.Errorf("failed to start dns server %s:%d: %q", ftpConf.BindHost, ftpConf.BindPort, err)
+				log.Errorf("failed to start ftp server %s:%d: %q", ftpConf.BindHost, ftpConf.BindPort, err)
 			}
 			continue
 		}
@@ -616,6 +788,630 @@ func setupFTP(rw *flamingo.RecordWriter) {
 	}
 }
 
+func setupFTPS(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	ftpsPorts, err := flamingo.CrackPorts(params.FTPSPorts)
+	if err != nil {
+		log.Fatalf("failed to process ftps ports %s: %s", params.FTPSPorts, err)
+	}
+
+	for _, port := range ftpsPorts {
+		ftpConf := flamingo.NewConfFTP()
+		ftpConf.BindPort = uint16(port)
+		ftpConf.RecordWriter = rw
+		ftpConf.TLS = true
+		ftpConf.TLSCert = params.TLSCertData
+		ftpConf.TLSKey = params.TLSKeyData
+		ftpConf.TLSName = params.TLSName
+		if err := flamingo.SpawnFTP(ftpConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start ftps server %s:%d: %q", ftpConf.BindHost, ftpConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start ftps server %s:%d: %q", ftpConf.BindHost, ftpConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { ftpConf.Shutdown() })
+	}
+}
+
+func setupSIP(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	sipPorts, err := flamingo.CrackPorts(params.SIPPorts)
+	if err != nil {
+		log.Fatalf("failed to process sip ports %s: %s", params.SIPPorts, err)
+	}
+
+	for _, port := range sipPorts {
+		sipConf := flamingo.NewConfSIP()
+		sipConf.BindPort = uint16(port)
+		sipConf.RecordWriter = rw
+		sipConf.Realm = params.SIPRealm
+		if err := flamingo.SpawnSIP(sipConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start sip server %s:%d: %q", sipConf.BindHost, sipConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start sip server %s:%d: %q", sipConf.BindHost, sipConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { sipConf.Shutdown() })
+	}
+}
+
+func setupSIPS(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	sipsPorts, err := flamingo.CrackPorts(params.SIPSPorts)
+	if err != nil {
+		log.Fatalf("failed to process sips ports %s: %s", params.SIPSPorts, err)
+	}
+
+	for _, port := range sipsPorts {
+		sipConf := flamingo.NewConfSIP()
+		sipConf.BindPort = uint16(port)
+		sipConf.RecordWriter = rw
+		sipConf.Realm = params.SIPRealm
+		sipConf.TLS = true
+		sipConf.TLSCert = params.TLSCertData
+		sipConf.TLSKey = params.TLSKeyData
+		sipConf.TLSName = params.TLSName
+		if err := flamingo.SpawnSIP(sipConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start sips server %s:%d: %q", sipConf.BindHost, sipConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start sips server %s:%d: %q", sipConf.BindHost, sipConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { sipConf.Shutdown() })
+	}
+}
+
+func setupIPMI(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	ipmiPorts, err := flamingo.CrackPorts(params.IPMIPorts)
+	if err != nil {
+		log.Fatalf("failed to process ipmi ports %s: %s", params.IPMIPorts, err)
+	}
+
+	for _, port := range ipmiPorts {
+		ipmiConf := flamingo.NewConfIPMI()
+		ipmiConf.BindPort = uint16(port)
+		ipmiConf.RecordWriter = rw
+		if err := flamingo.SpawnIPMI(ipmiConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start ipmi server %s:%d: %q", ipmiConf.BindHost, ipmiConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start ipmi server %s:%d: %q", ipmiConf.BindHost, ipmiConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { ipmiConf.Shutdown() })
+	}
+}
+
+func setupSOCKS(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	socksPorts, err := flamingo.CrackPorts(params.SOCKSPorts)
+	if err != nil {
+		log.Fatalf("failed to process socks ports %s: %s", params.SOCKSPorts, err)
+	}
+
+	for _, port := range socksPorts {
+		socksConf := flamingo.NewConfSOCKS()
+		socksConf.BindPort = uint16(port)
+		socksConf.RecordWriter = rw
+		if err := flamingo.SpawnSOCKS(socksConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start socks server %s:%d: %q", socksConf.BindHost, socksConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start socks server %s:%d: %q", socksConf.BindHost, socksConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { socksConf.Shutdown() })
+	}
+}
+
+func getResponderOptions() flamingo.ResponderOptions {
+	if params.ResponderIP != "" && net.ParseIP(params.ResponderIP) == nil {
+		log.Fatalf("invalid responder address %s", params.ResponderIP)
+	}
+
+	return flamingo.ResponderOptions{
+		ResolveToIP:  params.ResponderIP,
+		AnalyzeOnly:  params.ResponderAnalyze,
+		Interface:    params.ResponderInterface,
+		AllowNames:   splitList(params.ResponderAllow),
+		DenyNames:    splitList(params.ResponderDeny),
+		AllowSources: splitList(params.ResponderAllowSrc),
+		DenySources:  splitList(params.ResponderDenySrc),
+	}
+}
+
+// splitList turns a comma-separated list into a slice, skipping empty items
+func splitList(list string) []string {
+	res := []string{}
+	for item := range strings.SplitSeq(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
+}
+
+func setupLLMNR(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	llmnrPorts, err := flamingo.CrackPorts(params.LLMNRPorts)
+	if err != nil {
+		log.Fatalf("failed to process llmnr ports %s: %s", params.LLMNRPorts, err)
+	}
+
+	for _, port := range llmnrPorts {
+		llmnrConf := flamingo.NewConfLLMNR()
+		llmnrConf.BindPort = uint16(port)
+		llmnrConf.RecordWriter = rw
+		llmnrConf.ResponderOptions = getResponderOptions()
+		if err := flamingo.SpawnLLMNR(llmnrConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start llmnr server %s:%d: %q", llmnrConf.BindHost, llmnrConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start llmnr server %s:%d: %q", llmnrConf.BindHost, llmnrConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { llmnrConf.Shutdown() })
+	}
+}
+
+func setupNBNS(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	nbnsPorts, err := flamingo.CrackPorts(params.NBNSPorts)
+	if err != nil {
+		log.Fatalf("failed to process nbns ports %s: %s", params.NBNSPorts, err)
+	}
+
+	for _, port := range nbnsPorts {
+		nbnsConf := flamingo.NewConfNBNS()
+		nbnsConf.BindPort = uint16(port)
+		nbnsConf.RecordWriter = rw
+		nbnsConf.ResponderOptions = getResponderOptions()
+		if err := flamingo.SpawnNBNS(nbnsConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start nbns server %s:%d: %q", nbnsConf.BindHost, nbnsConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start nbns server %s:%d: %q", nbnsConf.BindHost, nbnsConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { nbnsConf.Shutdown() })
+	}
+}
+
+func setupMDNS(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	mdnsPorts, err := flamingo.CrackPorts(params.MDNSPorts)
+	if err != nil {
+		log.Fatalf("failed to process mdns ports %s: %s", params.MDNSPorts, err)
+	}
+
+	for _, port := range mdnsPorts {
+		mdnsConf := flamingo.NewConfMDNS()
+		mdnsConf.BindPort = uint16(port)
+		mdnsConf.RecordWriter = rw
+		mdnsConf.ResponderOptions = getResponderOptions()
+		if err := flamingo.SpawnMDNS(mdnsConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start mdns server %s:%d: %q", mdnsConf.BindHost, mdnsConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start mdns server %s:%d: %q", mdnsConf.BindHost, mdnsConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { mdnsConf.Shutdown() })
+	}
+}
+
+func setupOracle(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	oraclePorts, err := flamingo.CrackPorts(params.OraclePorts)
+	if err != nil {
+		log.Fatalf("failed to process oracle ports %s: %s", params.OraclePorts, err)
+	}
+
+	for _, port := range oraclePorts {
+		oracleConf := flamingo.NewConfOracle()
+		oracleConf.BindPort = uint16(port)
+		oracleConf.RecordWriter = rw
+		if err := flamingo.SpawnOracle(oracleConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start oracle server %s:%d: %q", oracleConf.BindHost, oracleConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start oracle server %s:%d: %q", oracleConf.BindHost, oracleConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { oracleConf.Shutdown() })
+	}
+}
+
+func setupMQTT(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	mqttPorts, err := flamingo.CrackPorts(params.MQTTPorts)
+	if err != nil {
+		log.Fatalf("failed to process mqtt ports %s: %s", params.MQTTPorts, err)
+	}
+
+	for _, port := range mqttPorts {
+		mqttConf := flamingo.NewConfMQTT()
+		mqttConf.BindPort = uint16(port)
+		mqttConf.RecordWriter = rw
+		if err := flamingo.SpawnMQTT(mqttConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start mqtt server %s:%d: %q", mqttConf.BindHost, mqttConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start mqtt server %s:%d: %q", mqttConf.BindHost, mqttConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { mqttConf.Shutdown() })
+	}
+}
+
+func setupMQTTS(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	mqttsPorts, err := flamingo.CrackPorts(params.MQTTSPorts)
+	if err != nil {
+		log.Fatalf("failed to process mqtts ports %s: %s", params.MQTTSPorts, err)
+	}
+
+	for _, port := range mqttsPorts {
+		mqttConf := flamingo.NewConfMQTT()
+		mqttConf.BindPort = uint16(port)
+		mqttConf.RecordWriter = rw
+		mqttConf.TLS = true
+		mqttConf.TLSCert = params.TLSCertData
+		mqttConf.TLSKey = params.TLSKeyData
+		mqttConf.TLSName = params.TLSName
+		if err := flamingo.SpawnMQTT(mqttConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start mqtts server %s:%d: %q", mqttConf.BindHost, mqttConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start mqtts server %s:%d: %q", mqttConf.BindHost, mqttConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { mqttConf.Shutdown() })
+	}
+}
+
+func setupAMQP(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	amqpPorts, err := flamingo.CrackPorts(params.AMQPPorts)
+	if err != nil {
+		log.Fatalf("failed to process amqp ports %s: %s", params.AMQPPorts, err)
+	}
+
+	for _, port := range amqpPorts {
+		amqpConf := flamingo.NewConfAMQP()
+		amqpConf.BindPort = uint16(port)
+		amqpConf.RecordWriter = rw
+		if err := flamingo.SpawnAMQP(amqpConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start amqp server %s:%d: %q", amqpConf.BindHost, amqpConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start amqp server %s:%d: %q", amqpConf.BindHost, amqpConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { amqpConf.Shutdown() })
+	}
+}
+
+func setupAMQPS(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	amqpsPorts, err := flamingo.CrackPorts(params.AMQPSPorts)
+	if err != nil {
+		log.Fatalf("failed to process amqps ports %s: %s", params.AMQPSPorts, err)
+	}
+
+	for _, port := range amqpsPorts {
+		amqpConf := flamingo.NewConfAMQP()
+		amqpConf.BindPort = uint16(port)
+		amqpConf.RecordWriter = rw
+		amqpConf.TLS = true
+		amqpConf.TLSCert = params.TLSCertData
+		amqpConf.TLSKey = params.TLSKeyData
+		amqpConf.TLSName = params.TLSName
+		if err := flamingo.SpawnAMQP(amqpConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start amqps server %s:%d: %q", amqpConf.BindHost, amqpConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start amqps server %s:%d: %q", amqpConf.BindHost, amqpConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { amqpConf.Shutdown() })
+	}
+}
+
+func setupRTSP(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	rtspPorts, err := flamingo.CrackPorts(params.RTSPPorts)
+	if err != nil {
+		log.Fatalf("failed to process rtsp ports %s: %s", params.RTSPPorts, err)
+	}
+
+	for _, port := range rtspPorts {
+		rtspConf := flamingo.NewConfRTSP()
+		rtspConf.BindPort = uint16(port)
+		rtspConf.RecordWriter = rw
+		rtspConf.Realm = params.RTSPRealm
+		rtspConf.AuthMode = params.RTSPAuthMode
+		if err := flamingo.SpawnRTSP(rtspConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start rtsp server %s:%d: %q", rtspConf.BindHost, rtspConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start rtsp server %s:%d: %q", rtspConf.BindHost, rtspConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { rtspConf.Shutdown() })
+	}
+}
+
+func setupXMPP(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	xmppPorts, err := flamingo.CrackPorts(params.XMPPPorts)
+	if err != nil {
+		log.Fatalf("failed to process xmpp ports %s: %s", params.XMPPPorts, err)
+	}
+
+	for _, port := range xmppPorts {
+		xmppConf := flamingo.NewConfXMPP()
+		xmppConf.BindPort = uint16(port)
+		xmppConf.RecordWriter = rw
+		xmppConf.TLSCert = params.TLSCertData
+		xmppConf.TLSKey = params.TLSKeyData
+		xmppConf.TLSName = params.TLSName
+		if err := flamingo.SpawnXMPP(xmppConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start xmpp server %s:%d: %q", xmppConf.BindHost, xmppConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start xmpp server %s:%d: %q", xmppConf.BindHost, xmppConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { xmppConf.Shutdown() })
+	}
+}
+
+func setupIRC(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	ircPorts, err := flamingo.CrackPorts(params.IRCPorts)
+	if err != nil {
+		log.Fatalf("failed to process irc ports %s: %s", params.IRCPorts, err)
+	}
+
+	for _, port := range ircPorts {
+		ircConf := flamingo.NewConfIRC()
+		ircConf.BindPort = uint16(port)
+		ircConf.RecordWriter = rw
+		if err := flamingo.SpawnIRC(ircConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start irc server %s:%d: %q", ircConf.BindHost, ircConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start irc server %s:%d: %q", ircConf.BindHost, ircConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { ircConf.Shutdown() })
+	}
+}
+
+func setupIRCS(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	ircsPorts, err := flamingo.CrackPorts(params.IRCSPorts)
+	if err != nil {
+		log.Fatalf("failed to process ircs ports %s: %s", params.IRCSPorts, err)
+	}
+
+	for _, port := range ircsPorts {
+		ircConf := flamingo.NewConfIRC()
+		ircConf.BindPort = uint16(port)
+		ircConf.RecordWriter = rw
+		ircConf.TLS = true
+		ircConf.TLSCert = params.TLSCertData
+		ircConf.TLSKey = params.TLSKeyData
+		ircConf.TLSName = params.TLSName
+		if err := flamingo.SpawnIRC(ircConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start ircs server %s:%d: %q", ircConf.BindHost, ircConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start ircs server %s:%d: %q", ircConf.BindHost, ircConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { ircConf.Shutdown() })
+	}
+}
+
+func setupNNTP(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	nntpPorts, err := flamingo.CrackPorts(params.NNTPPorts)
+	if err != nil {
+		log.Fatalf("failed to process nntp ports %s: %s", params.NNTPPorts, err)
+	}
+
+	for _, port := range nntpPorts {
+		nntpConf := flamingo.NewConfNNTP()
+		nntpConf.BindPort = uint16(port)
+		nntpConf.RecordWriter = rw
+		if err := flamingo.SpawnNNTP(nntpConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start nntp server %s:%d: %q", nntpConf.BindHost, nntpConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start nntp server %s:%d: %q", nntpConf.BindHost, nntpConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { nntpConf.Shutdown() })
+	}
+}
+
+func setupNNTPS(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	nntpsPorts, err := flamingo.CrackPorts(params.NNTPSPorts)
+	if err != nil {
+		log.Fatalf("failed to process nntps ports %s: %s", params.NNTPSPorts, err)
+	}
+
+	for _, port := range nntpsPorts {
+		nntpConf := flamingo.NewConfNNTP()
+		nntpConf.BindPort = uint16(port)
+		nntpConf.RecordWriter = rw
+		nntpConf.TLS = true
+		nntpConf.TLSCert = params.TLSCertData
+		nntpConf.TLSKey = params.TLSKeyData
+		nntpConf.TLSName = params.TLSName
+		if err := flamingo.SpawnNNTP(nntpConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start nntps server %s:%d: %q", nntpConf.BindHost, nntpConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start nntps server %s:%d: %q", nntpConf.BindHost, nntpConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { nntpConf.Shutdown() })
+	}
+}
+
+func setupRSYNC(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	rsyncPorts, err := flamingo.CrackPorts(params.RSYNCPorts)
+	if err != nil {
+		log.Fatalf("failed to process rsync ports %s: %s", params.RSYNCPorts, err)
+	}
+
+	for _, port := range rsyncPorts {
+		rsyncConf := flamingo.NewConfRSYNC()
+		rsyncConf.BindPort = uint16(port)
+		rsyncConf.RecordWriter = rw
+		rsyncConf.Modules = splitList(params.RSYNCModules)
+		if err := flamingo.SpawnRSYNC(rsyncConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start rsync server %s:%d: %q", rsyncConf.BindHost, rsyncConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start rsync server %s:%d: %q", rsyncConf.BindHost, rsyncConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { rsyncConf.Shutdown() })
+	}
+}
+
+func setupSNMPTrap(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	snmpTrapPorts, err := flamingo.CrackPorts(params.SNMPTrapPorts)
+	if err != nil {
+		log.Fatalf("failed to process snmptrap ports %s: %s", params.SNMPTrapPorts, err)
+	}
+
+	for _, port := range snmpTrapPorts {
+		snmpTrapConf := flamingo.NewConfSNMPTrap()
+		snmpTrapConf.BindPort = uint16(port)
+		snmpTrapConf.RecordWriter = rw
+		if params.SNMPEngineID != "" {
+			snmpTrapConf.EngineID = params.SNMPEngineID
+		}
+		snmpTrapConf.EngineBoots = params.SNMPEngineBoots
+		snmpTrapConf.EngineTime = params.SNMPEngineTime
+		if err := flamingo.SpawnSNMPTrap(snmpTrapConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start snmptrap server %s:%d: %q", snmpTrapConf.BindHost, snmpTrapConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start snmptrap server %s:%d: %q", snmpTrapConf.BindHost, snmpTrapConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { snmpTrapConf.Shutdown() })
+	}
+}
+
+func setupCLDAP(rw *flamingo.RecordWriter) {
+
+	// Create a listener for each port
+	cldapPorts, err := flamingo.CrackPorts(params.CLDAPPorts)
+	if err != nil {
+		log.Fatalf("failed to process cldap ports %s: %s", params.CLDAPPorts, err)
+	}
+
+	for _, port := range cldapPorts {
+		cldapConf := flamingo.NewConfCLDAP()
+		cldapConf.BindPort = uint16(port)
+		cldapConf.RecordWriter = rw
+		cldapConf.Domain = params.CLDAPDomain
+		cldapConf.NetBIOSDomain = params.CLDAPNetBIOS
+		cldapConf.SiteName = params.CLDAPSite
+		cldapConf.DomainGUID = params.CLDAPDomainGUID
+		cldapConf.HostName = params.TLSName
+		cldapConf.DCAddress = params.ResponderIP
+		if err := flamingo.SpawnCLDAP(cldapConf); err != nil {
+			if params.DontIgnoreFailures {
+				log.Fatalf("failed to start cldap server %s:%d: %q", cldapConf.BindHost, cldapConf.BindPort, err)
+			} else {
+				log.Errorf("failed to start cldap server %s:%d: %q", cldapConf.BindHost, cldapConf.BindPort, err)
+			}
+			continue
+		}
+		protocolCount++
+		cleanupHandlers = append(cleanupHandlers, func() { cldapConf.Shutdown() })
+	}
+}
+
 func sendWebhook(url string, msg string) error {
 	body, _ := json.Marshal(map[string]string{"text": msg})
 	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -18,17 +19,69 @@ type flamingoParameters struct {
 	Verbose            bool
 	DontIgnoreFailures bool
 	FTPPorts           string
+	FTPSPorts          string
 	DNSPorts           string
 	DNSResolveToIP     string
 	SNMPPorts          string
+	SNMPEngineID       string
+	SNMPEngineBoots    uint32
+	SNMPEngineTime     uint32
 	SSHPorts           string
 	SSHHostKey         string
+	SSHPrompts         string
+	SSHShell           bool
+	SSHShellHostname   string
+	SSHSessionTimeout  time.Duration
 	LDAPPorts          string
 	LDAPSPorts         string
+	LDAPBaseDN         string
+	LDAPFunctional     string
+	LDAPDirectory      string
+	LDAPDirectoryData  string
 	HTTPPorts          string
 	HTTPSPorts         string
 	HTTPBasicRealm     string
 	HTTPAuthMode       string
+	WPADProxy          string
+	WPADAuthMode       string
+	ProxyPorts         string
+	ProxyBasicRealm    string
+	SIPPorts           string
+	SIPSPorts          string
+	SIPRealm           string
+	IPMIPorts          string
+	SOCKSPorts         string
+	LLMNRPorts         string
+	NBNSPorts          string
+	MDNSPorts          string
+	ResponderIP        string
+	ResponderAnalyze   bool
+	ResponderInterface string
+	ResponderAllow     string
+	ResponderDeny      string
+	ResponderAllowSrc  string
+	ResponderDenySrc   string
+	OraclePorts        string
+	MQTTPorts          string
+	MQTTSPorts         string
+	AMQPPorts          string
+	AMQPSPorts         string
+	RTSPPorts          string
+	RTSPRealm          string
+	RTSPAuthMode       string
+	XMPPPorts          string
+	IRCPorts           string
+	IRCSPorts          string
+	NNTPPorts          string
+	NNTPSPorts         string
+	RSYNCPorts         string
+	RSYNCModules       string
+	SNMPTrapPorts      string
+	CLDAPPorts         string
+	CLDAPDomain        string
+	CLDAPNetBIOS       string
+	CLDAPSite          string
+	CLDAPDomainGUID    string
 	TLSCertFile        string
 	TLSCertData        string
 	TLSKeyFile         string
@@ -69,14 +122,24 @@ func init() {
 
 	// SNMP parameters
 	rootCmd.Flags().StringVarP(&params.SNMPPorts, "snmp-ports", "", "161", "The list of UDP ports to listen on for SNMP")
+	rootCmd.Flags().StringVarP(&params.SNMPEngineID, "snmp-engine-id", "", "", "The hex-encoded SNMPv3 engine ID to report (random if not specified)")
+	rootCmd.Flags().Uint32VarP(&params.SNMPEngineBoots, "snmp-engine-boots", "", 1, "The SNMPv3 engine boot count to report")
+	rootCmd.Flags().Uint32VarP(&params.SNMPEngineTime, "snmp-engine-time", "", 0, "The SNMPv3 engine time to report at startup")
 
 	// SSH parameters
 	rootCmd.Flags().StringVarP(&params.SSHPorts, "ssh-ports", "", "22", "The list of TCP ports to listen on for SSH")
 	rootCmd.Flags().StringVarP(&params.SSHHostKey, "ssh-host-key", "", "", "An optional path to a SSH host key on disk")
+	rootCmd.Flags().StringVarP(&params.SSHPrompts, "ssh-prompts", "", "Password:", "A comma-separated list of SSH keyboard-interactive prompts. If empty, keyboard-interactive authentication is disabled")
+	rootCmd.Flags().BoolVarP(&params.SSHShell, "ssh-shell", "", false, "Accept SSH logins and record the commands and sudo passwords sent to a fake shell")
+	rootCmd.Flags().StringVarP(&params.SSHShellHostname, "ssh-shell-hostname", "", "web01", "The host name presented by the SSH fake shell")
+	rootCmd.Flags().DurationVarP(&params.SSHSessionTimeout, "ssh-session-timeout", "", 2*time.Minute, "The maximum duration of SSH fake shell sessions")
 
 	// LDAP(S) parameters
 	rootCmd.Flags().StringVarP(&params.LDAPPorts, "ldap-ports", "", "389", "The list of TCP ports to listen on for LDAP")
 	rootCmd.Flags().StringVarP(&params.LDAPSPorts, "ldaps-ports", "", "636", "The list of TCP ports to listen on for LDAPS")
+	rootCmd.Flags().StringVarP(&params.LDAPBaseDN, "ldap-base-dn", "", "DC=corp,DC=local", "The LDAP naming context to report in the rootDSE")
+	rootCmd.Flags().StringVarP(&params.LDAPFunctional, "ldap-functional-level", "", "7", "The domain, forest, and DC functionality level to report in the rootDSE")
+	rootCmd.Flags().StringVarP(&params.LDAPDirectory, "ldap-ldif", "", "", "An optional path to a LDIF file with entries to serve from the LDAP listeners")
 
 	// DNS parameters
 	rootCmd.Flags().StringVarP(&params.DNSPorts, "dns-ports", "", "53,5353", "The list of UDP ports to listen on for DNS")
@@ -84,12 +147,86 @@ func init() {
 
 	// FTP parameters
 	rootCmd.Flags().StringVarP(&params.FTPPorts, "ftp-ports", "", "21", "The list of TCP ports to listen on for FTP")
+	rootCmd.Flags().StringVarP(&params.FTPSPorts, "ftps-ports", "", "990", "The list of TCP ports to listen on for FTP over implicit TLS")
 
 	// HTTP(S) parameters
 	rootCmd.Flags().StringVarP(&params.HTTPPorts, "http-ports", "", "80", "The list of TCP ports to listen on for HTTP")
 	rootCmd.Flags().StringVarP(&params.HTTPSPorts, "https-ports", "", "443", "The list of TCP ports to listen on for HTTPS")
 	rootCmd.Flags().StringVarP(&params.HTTPBasicRealm, "http-realm", "", "Administration", "The HTTP basic authentication realm to present")
-	rootCmd.Flags().StringVarP(&params.HTTPAuthMode, "http-auth-mode", "", "ntlm", "The authentication mode for the HTTP listeners (ntlm or basic)")
+	rootCmd.Flags().StringVarP(&params.HTTPAuthMode, "http-auth-mode", "", "ntlm", "The authentication mode for the HTTP listeners (ntlm, basic, or registry)")
+
+	rootCmd.Flags().StringVarP(&params.WPADProxy, "wpad-proxy", "", "", "The proxy host:port to serve in /wpad.dat and /proxy.pac from the HTTP listeners. If empty, no PAC file is served")
+	rootCmd.Flags().StringVarP(&params.WPADAuthMode, "wpad-auth-mode", "", "none", "The authentication mode for PAC file requests (none, ntlm, or basic)")
+
+	// HTTP proxy parameters
+	rootCmd.Flags().StringVarP(&params.ProxyPorts, "proxy-ports", "", "3128,8080", "The list of TCP ports to listen on for HTTP proxy requests")
+	rootCmd.Flags().StringVarP(&params.ProxyBasicRealm, "proxy-realm", "", "Squid proxy-caching web server", "The HTTP proxy basic authentication realm to present")
+
+	// SIP(S) parameters
+	rootCmd.Flags().StringVarP(&params.SIPPorts, "sip-ports", "", "5060", "The list of UDP and TCP ports to listen on for SIP")
+	rootCmd.Flags().StringVarP(&params.SIPSPorts, "sips-ports", "", "5061", "The list of TCP ports to listen on for SIP over TLS")
+	rootCmd.Flags().StringVarP(&params.SIPRealm, "sip-realm", "", "asterisk", "The SIP digest authentication realm to present")
+
+	// IPMI parameters
+	rootCmd.Flags().StringVarP(&params.IPMIPorts, "ipmi-ports", "", "623", "The list of UDP ports to listen on for IPMI")
+
+	// SOCKS parameters
+	rootCmd.Flags().StringVarP(&params.SOCKSPorts, "socks-ports", "", "1080", "The list of TCP ports to listen on for SOCKS")
+
+	// LLMNR, NBT-NS, and mDNS responder parameters
+	rootCmd.Flags().StringVarP(&params.LLMNRPorts, "llmnr-ports", "", "5355", "The list of UDP ports to listen on for LLMNR queries")
+
+	rootCmd.Flags().StringVarP(&params.NBNSPorts, "nbns-ports", "", "137", "The list of UDP ports to listen on for NBT-NS queries")
+
+	rootCmd.Flags().StringVarP(&params.MDNSPorts, "mdns-ports", "", "5353", "The list of UDP ports to listen on for mDNS queries (conflicts with the dns listener on 5353)")
+	rootCmd.Flags().StringVarP(&params.ResponderIP, "responder-ip", "", "", "The IP address used to answer name queries. If empty, the local address facing the client is used")
+	rootCmd.Flags().BoolVarP(&params.ResponderAnalyze, "responder-analyze", "", false, "Record name queries without answering them")
+	rootCmd.Flags().StringVarP(&params.ResponderInterface, "responder-interface", "", "", "The network interface used to join LLMNR and mDNS multicast groups")
+	rootCmd.Flags().StringVarP(&params.ResponderAllow, "responder-allow-names", "", "", "A comma-separated list of names or patterns to answer (default all)")
+	rootCmd.Flags().StringVarP(&params.ResponderDeny, "responder-deny-names", "", "", "A comma-separated list of names or patterns to never answer")
+	rootCmd.Flags().StringVarP(&params.ResponderAllowSrc, "responder-allow-sources", "", "", "A comma-separated list of client addresses or CIDRs to answer (default all)")
+	rootCmd.Flags().StringVarP(&params.ResponderDenySrc, "responder-deny-sources", "", "", "A comma-separated list of client addresses or CIDRs to never answer")
+
+	// Oracle parameters
+	rootCmd.Flags().StringVarP(&params.OraclePorts, "oracle-ports", "", "1521", "The list of TCP ports to listen on for Oracle TNS")
+
+	// MQTT parameters
+	rootCmd.Flags().StringVarP(&params.MQTTPorts, "mqtt-ports", "", "1883", "The list of TCP ports to listen on for MQTT")
+	rootCmd.Flags().StringVarP(&params.MQTTSPorts, "mqtts-ports", "", "8883", "The list of TCP ports to listen on for MQTT over TLS")
+
+	// AMQP parameters
+	rootCmd.Flags().StringVarP(&params.AMQPPorts, "amqp-ports", "", "5672", "The list of TCP ports to listen on for AMQP")
+	rootCmd.Flags().StringVarP(&params.AMQPSPorts, "amqps-ports", "", "5671", "The list of TCP ports to listen on for AMQP over TLS")
+
+	// RTSP parameters
+	rootCmd.Flags().StringVarP(&params.RTSPPorts, "rtsp-ports", "", "554", "The list of TCP ports to listen on for RTSP")
+	rootCmd.Flags().StringVarP(&params.RTSPRealm, "rtsp-realm", "", "IP Camera", "The RTSP authentication realm to present")
+	rootCmd.Flags().StringVarP(&params.RTSPAuthMode, "rtsp-auth-mode", "", "basic", "The authentication mode for the RTSP listeners (basic, digest, or both)")
+
+	// XMPP parameters
+	rootCmd.Flags().StringVarP(&params.XMPPPorts, "xmpp-ports", "", "5222", "The list of TCP ports to listen on for XMPP")
+
+	// IRC parameters
+	rootCmd.Flags().StringVarP(&params.IRCPorts, "irc-ports", "", "6667", "The list of TCP ports to listen on for IRC")
+	rootCmd.Flags().StringVarP(&params.IRCSPorts, "ircs-ports", "", "6697", "The list of TCP ports to listen on for IRC over TLS")
+
+	// NNTP parameters
+	rootCmd.Flags().StringVarP(&params.NNTPPorts, "nntp-ports", "", "119", "The list of TCP ports to listen on for NNTP")
+	rootCmd.Flags().StringVarP(&params.NNTPSPorts, "nntps-ports", "", "563", "The list of TCP ports to listen on for NNTP over TLS")
+
+	// rsync parameters
+	rootCmd.Flags().StringVarP(&params.RSYNCPorts, "rsync-ports", "", "873", "The list of TCP ports to listen on for rsync")
+	rootCmd.Flags().StringVarP(&params.RSYNCModules, "rsync-modules", "", "backup,data", "A comma-separated list of module names to advertise on the rsync listeners")
+
+	// SNMP trap parameters
+	rootCmd.Flags().StringVarP(&params.SNMPTrapPorts, "snmptrap-ports", "", "162", "The list of UDP ports to listen on for SNMP traps and informs")
+
+	// CLDAP parameters
+	rootCmd.Flags().StringVarP(&params.CLDAPPorts, "cldap-ports", "", "389", "The list of UDP ports to listen on for CLDAP netlogon pings")
+	rootCmd.Flags().StringVarP(&params.CLDAPDomain, "cldap-domain", "", "corp.local", "The DNS domain name to report in netlogon responses")
+	rootCmd.Flags().StringVarP(&params.CLDAPNetBIOS, "cldap-netbios-domain", "", "", "The NetBIOS domain name to report in netlogon responses (derived from the domain if not specified)")
+	rootCmd.Flags().StringVarP(&params.CLDAPSite, "cldap-site", "", "Default-First-Site-Name", "The site name to report in netlogon responses")
+	rootCmd.Flags().StringVarP(&params.CLDAPDomainGUID, "cldap-domain-guid", "", "", "The domain GUID to report in netlogon responses (random if not specified)")
 
 	rootCmd.Flags().StringVarP(&params.TLSCertFile, "tls-cert", "", "", "An optional x509 certificate for TLS listeners")
 	rootCmd.Flags().StringVarP(&params.TLSKeyFile, "tls-key", "", "", "An optional x509 key for TLS listeners")
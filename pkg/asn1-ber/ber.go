@@ -332,7 +332,7 @@ func decodePacket(data []byte) (*Packet, []byte, error) {
 	p.TagType = data[0] & TypeBitmask
 	p.Tag = data[0] & TagBitmask
 
-	if dlen < 3 {
+	if dlen < 2 {
 		return p, data, fmt.Errorf("packet too short")
 	}
 
@@ -433,19 +433,27 @@ func (p *Packet) DataLength() uint64 {
 	return uint64(p.Data.Len())
 }
 
+// encodeLength returns the definite-form length octets for a packet. Lengths
+// are unsigned, so unlike EncodeInteger no sign padding is added. DER requires
+// the minimal form, and strict decoders such as the SPNEGO implementations in
+// browsers and Go's encoding/asn1 reject 82 00 80 in place of 81 80.
+func encodeLength(length uint64) []byte {
+	if length <= 127 {
+		return []byte{byte(length)}
+	}
+
+	packet_length := EncodeInteger(length)
+	if len(packet_length) > 1 && packet_length[0] == 0x00 {
+		packet_length = packet_length[1:]
+	}
+	return append([]byte{byte(len(packet_length) | 128)}, packet_length...)
+}
+
 func (p *Packet) Bytes() []byte {
 	var out bytes.Buffer
 
 	out.Write([]byte{p.ClassType | p.TagType | p.Tag})
-	packet_length := EncodeInteger(p.DataLength())
-
-	if p.DataLength() > 127 || len(packet_length) > 1 {
-		out.Write([]byte{byte(len(packet_length) | 128)})
-		out.Write(packet_length)
-	} else {
-		out.Write(packet_length)
-	}
-
+	out.Write(encodeLength(p.DataLength()))
 	out.Write(p.Data.Bytes())
 
 	return out.Bytes()
@@ -0,0 +1,85 @@
+package ber
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"testing"
+)
+
+func TestPacketBytesLength(t *testing.T) {
+	tests := []struct {
+		length int
+		header []byte
+	}{
+		{0, []byte{0x04, 0x00}},
+		{1, []byte{0x04, 0x01}},
+		{127, []byte{0x04, 0x7f}},
+		{128, []byte{0x04, 0x81, 0x80}},
+		{200, []byte{0x04, 0x81, 0xc8}},
+		{255, []byte{0x04, 0x81, 0xff}},
+		{256, []byte{0x04, 0x82, 0x01, 0x00}},
+		{32767, []byte{0x04, 0x82, 0x7f, 0xff}},
+		{32768, []byte{0x04, 0x82, 0x80, 0x00}},
+		{65535, []byte{0x04, 0x82, 0xff, 0xff}},
+		{65536, []byte{0x04, 0x83, 0x01, 0x00, 0x00}},
+		{8388608, []byte{0x04, 0x83, 0x80, 0x00, 0x00}},
+	}
+
+	for _, tt := range tests {
+		value := string(bytes.Repeat([]byte{'a'}, tt.length))
+		data := NewString(ClassUniversal, TypePrimitive, TagOctetString, value, "value").Bytes()
+
+		if !bytes.HasPrefix(data, tt.header) || len(data) != len(tt.header)+tt.length {
+			t.Errorf("length %d: got header %x, want %x", tt.length, data[:len(tt.header)], tt.header)
+			continue
+		}
+
+		// The length must use the minimal DER form
+		var raw asn1.RawValue
+		if rest, err := asn1.Unmarshal(data, &raw); err != nil || len(rest) != 0 {
+			t.Errorf("length %d: encoding/asn1 rejected encoding: %v", tt.length, err)
+			continue
+		}
+
+		p, err := DecodePacket(data)
+		if err != nil {
+			t.Errorf("length %d: failed to decode: %s", tt.length, err)
+			continue
+		}
+		if p.Value != value {
+			t.Errorf("length %d: decoded value of length %d", tt.length, len(p.Value.(string)))
+		}
+	}
+}
+
+func TestDecodePacketEmptyElement(t *testing.T) {
+	for _, data := range [][]byte{{0x04, 0x00}, {0x30, 0x00}} {
+		p, err := DecodePacket(data)
+		if err != nil {
+			t.Errorf("%x: failed to decode: %s", data, err)
+			continue
+		}
+		if p.DataLength() != 0 || len(p.Children) != 0 {
+			t.Errorf("%x: decoded %d bytes and %d children", data, p.DataLength(), len(p.Children))
+		}
+	}
+
+	// A rootDSE search from ldapsearch -b "" -s base, with an empty base DN
+	// and an empty attribute list as the final element
+	data := []byte{
+		0x30, 0x25, 0x02, 0x01, 0x01, 0x63, 0x20, 0x04, 0x00, 0x0a, 0x01, 0x00, 0x0a, 0x01, 0x00,
+		0x02, 0x01, 0x00, 0x02, 0x01, 0x00, 0x01, 0x01, 0x00, 0x87, 0x0b, 'o', 'b', 'j', 'e', 'c',
+		't', 'c', 'l', 'a', 's', 's', 0x30, 0x00,
+	}
+	p, err := DecodePacket(data)
+	if err != nil {
+		t.Fatalf("failed to decode search request: %s", err)
+	}
+	if len(p.Children) != 2 || len(p.Children[1].Children) != 8 {
+		t.Fatalf("decoded search request with unexpected children")
+	}
+	search := p.Children[1]
+	if search.Children[0].Value != "" || len(search.Children[7].Children) != 0 {
+		t.Errorf("decoded base DN %q and %d attributes", search.Children[0].Value, len(search.Children[7].Children))
+	}
+}
@@ -0,0 +1,454 @@
+package flamingo
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	amqpFrameMethod = 1
+	amqpFrameEnd    = 0xce
+
+	amqpClassConnection = 10
+
+	amqpMethodStart   = 10
+	amqpMethodStartOk = 11
+	amqpMethodTune    = 30
+	amqpMethodTuneOk  = 31
+	amqpMethodOpen    = 40
+	amqpMethodClose   = 50
+
+	amqpReplyAccessRefused = 403
+
+	// amqpMaxFrameSize limits the size of a frame read from a client
+	amqpMaxFrameSize = 131072
+)
+
+// amqpProtocolHeader is the protocol header for AMQP 0-9-1
+var amqpProtocolHeader = []byte{'A', 'M', 'Q', 'P', 0, 0, 9, 1}
+
+// ConfAMQP describes the options for an AMQP service
+type ConfAMQP struct {
+	BindPort     uint16
+	BindHost     string
+	RecordWriter *RecordWriter
+	TLS          bool
+	TLSName      string
+	TLSCert      string
+	TLSKey       string
+	shutdown     bool
+	listener     net.Listener
+	m            sync.Mutex
+}
+
+// IsShutdown checks to see if the service is shutting down
+func (c *ConfAMQP) IsShutdown() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.shutdown
+}
+
+// Shutdown flags the service to shut down
+func (c *ConfAMQP) Shutdown() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.shutdown = true
+	c.listener.Close()
+}
+
+// NewConfAMQP creates a default configuration for the AMQP capture server
+func NewConfAMQP() *ConfAMQP {
+	return &ConfAMQP{
+		BindPort: 5672,
+		BindHost: "[::]",
+	}
+}
+
+// SpawnAMQP starts a logging AMQP server, using TLS if configured
+func SpawnAMQP(c *ConfAMQP) error {
+
+	// Handle TLS listeners
+	if c.TLS {
+		tlsConfig := tls.Config{ServerName: c.TLSName}
+		kp, err := tls.X509KeyPair([]byte(c.TLSCert), []byte(c.TLSKey))
+		if err != nil {
+			return fmt.Errorf("failed to load tls cert for amqps on %s:%d (%s)", c.BindHost, c.BindPort, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{kp}
+
+		listener, err := tls.Listen("tcp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort), &tlsConfig)
+		if err != nil {
+			return fmt.Errorf("failed to listen with tls on %s:%d (%s)", c.BindHost, c.BindPort, err)
+		}
+		c.listener = listener
+		go amqpStart(c)
+		return nil
+	}
+
+	// Handle normal listeners
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s:%d (%s)", c.BindHost, c.BindPort, err)
+	}
+	c.listener = listener
+	go amqpStart(c)
+	return nil
+}
+
+func (c *ConfAMQP) protoName() string {
+	if c.TLS {
+		return "amqps"
+	}
+	return "amqp"
+}
+
+func amqpStart(c *ConfAMQP) {
+	log.Debugf("%s is listening on %s:%d", c.protoName(), c.BindHost, c.BindPort)
+	for {
+		if c.IsShutdown() {
+			log.Debugf("%s server on %s:%d is shutting down", c.protoName(), c.BindHost, c.BindPort)
+			break
+		}
+		conn, err := c.listener.Accept()
+		if err != nil {
+			// Triggers on shutdown, will break on reiteration of the loop
+			continue
+		}
+		go amqpHandleConnectionWrapped(c, conn)
+	}
+}
+
+func amqpHandleConnectionWrapped(c *ConfAMQP, conn net.Conn) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Debugf("%s connection handler panic: %q", c.protoName(), r)
+		}
+	}()
+	amqpHandleConnection(c, conn)
+}
+
+// amqpReadMethod reads a method frame on the connection channel and returns the class, method, and arguments
+func amqpReadMethod(reader *bufio.Reader) (uint16, uint16, []byte, error) {
+	for {
+		hdr := make([]byte, 7)
+		if _, err := io.ReadFull(reader, hdr); err != nil {
+			return 0, 0, nil, err
+		}
+
+		size := binary.BigEndian.Uint32(hdr[3:7])
+		if size > amqpMaxFrameSize {
+			return 0, 0, nil, fmt.Errorf("frame too large (%d)", size)
+		}
+
+		payload := make([]byte, size+1)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return 0, 0, nil, err
+		}
+		if payload[size] != amqpFrameEnd {
+			return 0, 0, nil, fmt.Errorf("invalid frame end")
+		}
+
+		// Ignore heartbeats and other frame types
+		if hdr[0] != amqpFrameMethod || size < 4 {
+			continue
+		}
+		return binary.BigEndian.Uint16(payload[0:2]), binary.BigEndian.Uint16(payload[2:4]), payload[4:size], nil
+	}
+}
+
+// amqpWriteMethod sends a method frame on the connection channel
+func amqpWriteMethod(conn net.Conn, class uint16, method uint16, args []byte) error {
+	frame := []byte{amqpFrameMethod, 0x00, 0x00}
+	frame = binary.BigEndian.AppendUint32(frame, uint32(4+len(args)))
+	frame = binary.BigEndian.AppendUint16(frame, class)
+	frame = binary.BigEndian.AppendUint16(frame, method)
+	frame = append(frame, args...)
+	frame = append(frame, amqpFrameEnd)
+	_, err := conn.Write(frame)
+	return err
+}
+
+// amqpAppendShortString appends a short string argument
+func amqpAppendShortString(buff []byte, val string) []byte {
+	if len(val) > 255 {
+		val = val[:255]
+	}
+	buff = append(buff, byte(len(val)))
+	return append(buff, val...)
+}
+
+// amqpAppendLongString appends a long string argument
+func amqpAppendLongString(buff []byte, val string) []byte {
+	buff = binary.BigEndian.AppendUint32(buff, uint32(len(val)))
+	return append(buff, val...)
+}
+
+// amqpReadShortString decodes a short string argument, returning the new offset
+func amqpReadShortString(data []byte, idx int) (string, int, error) {
+	if idx >= len(data) || idx+1+int(data[idx]) > len(data) {
+		return "", idx, io.ErrUnexpectedEOF
+	}
+	size := int(data[idx])
+	return string(data[idx+1 : idx+1+size]), idx + 1 + size, nil
+}
+
+// amqpReadLongString decodes a long string argument, returning the new offset
+func amqpReadLongString(data []byte, idx int) (string, int, error) {
+	if idx+4 > len(data) {
+		return "", idx, io.ErrUnexpectedEOF
+	}
+	size := int(binary.BigEndian.Uint32(data[idx:]))
+	idx += 4
+	if size > len(data)-idx {
+		return "", idx, io.ErrUnexpectedEOF
+	}
+	return string(data[idx : idx+size]), idx + size, nil
+}
+
+// amqpReadTable decodes a length-prefixed field table, returning the new offset
+func amqpReadTable(data []byte, idx int) (map[string]string, int, error) {
+	raw, idx, err := amqpReadLongString(data, idx)
+	if err != nil {
+		return nil, idx, err
+	}
+	table, err := amqpDecodeTable([]byte(raw))
+	return table, idx, err
+}
+
+// amqpDecodeTable decodes the entries of a field table, rendering each value as a string
+func amqpDecodeTable(data []byte) (map[string]string, error) {
+	table := make(map[string]string)
+	idx := 0
+	for idx < len(data) {
+		name, nidx, err := amqpReadShortString(data, idx)
+		if err != nil {
+			return table, err
+		}
+		val, vidx, err := amqpDecodeValue(data, nidx)
+		if err != nil {
+			return table, err
+		}
+		table[name] = val
+		idx = vidx
+	}
+	return table, nil
+}
+
+// amqpDecodeValue decodes a single field value, returning the new offset
+func amqpDecodeValue(data []byte, idx int) (string, int, error) {
+	if idx >= len(data) {
+		return "", idx, io.ErrUnexpectedEOF
+	}
+	kind := data[idx]
+	idx++
+
+	// Fixed-size values
+	sizes := map[byte]int{'t': 1, 'b': 1, 'B': 1, 's': 2, 'u': 2, 'I': 4, 'i': 4, 'f': 4, 'l': 8, 'd': 8, 'T': 8, 'D': 5, 'V': 0}
+	if size, ok := sizes[kind]; ok {
+		if idx+size > len(data) {
+			return "", idx, io.ErrUnexpectedEOF
+		}
+		v := data[idx : idx+size]
+		var val string
+		switch kind {
+		case 't':
+			val = strconv.FormatBool(v[0] != 0)
+		case 'b':
+			val = strconv.Itoa(int(int8(v[0])))
+		case 'B':
+			val = strconv.Itoa(int(v[0]))
+		case 's':
+			val = strconv.Itoa(int(int16(binary.BigEndian.Uint16(v))))
+		case 'u':
+			val = strconv.Itoa(int(binary.BigEndian.Uint16(v)))
+		case 'I':
+			val = strconv.Itoa(int(int32(binary.BigEndian.Uint32(v))))
+		case 'i':
+			val = strconv.FormatUint(uint64(binary.BigEndian.Uint32(v)), 10)
+		case 'f':
+			val = strconv.FormatFloat(float64(math.Float32frombits(binary.BigEndian.Uint32(v))), 'g', -1, 32)
+		case 'l':
+			val = strconv.FormatInt(int64(binary.BigEndian.Uint64(v)), 10)
+		case 'd':
+			val = strconv.FormatFloat(math.Float64frombits(binary.BigEndian.Uint64(v)), 'g', -1, 64)
+		case 'T':
+			val = time.Unix(int64(binary.BigEndian.Uint64(v)), 0).UTC().Format(time.RFC3339)
+		case 'D':
+			val = strconv.FormatFloat(float64(int32(binary.BigEndian.Uint32(v[1:])))/math.Pow10(int(v[0])), 'f', -1, 64)
+		}
+		return val, idx + size, nil
+	}
+
+	switch kind {
+	case 'S', 'x':
+		return amqpReadLongString(data, idx)
+
+	case 'F':
+		table, nidx, err := amqpReadTable(data, idx)
+		if err != nil {
+			return "", nidx, err
+		}
+		return amqpFormatTable(table), nidx, nil
+
+	case 'A':
+		raw, nidx, err := amqpReadLongString(data, idx)
+		if err != nil {
+			return "", nidx, err
+		}
+		items := []string{}
+		for aidx := 0; aidx < len(raw); {
+			item, next, err := amqpDecodeValue([]byte(raw), aidx)
+			if err != nil {
+				return "", nidx, err
+			}
+			items = append(items, item)
+			aidx = next
+		}
+		return "[" + strings.Join(items, ",") + "]", nidx, nil
+	}
+
+	return "", idx, fmt.Errorf("unknown field type %q", kind)
+}
+
+// amqpFormatTable renders a nested table in a stable order
+func amqpFormatTable(table map[string]string) string {
+	keys := make([]string, 0, len(table))
+	for k := range table {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+table[k])
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// amqpStartArgs creates the arguments for Connection.Start
+func amqpStartArgs() []byte {
+	props := []byte{}
+	for _, kv := range [][2]string{
+		{"cluster_name", "rabbit@localhost"},
+		{"copyright", "Copyright (c) 2007-2023 VMware, Inc. or its affiliates."},
+		{"platform", "Erlang/OTP 25.3"},
+		{"product", "RabbitMQ"},
+		{"version", "3.12.0"},
+	} {
+		props = amqpAppendShortString(props, kv[0])
+		props = append(props, 'S')
+		props = amqpAppendLongString(props, kv[1])
+	}
+
+	args := []byte{0x00, 0x09}
+	args = amqpAppendLongString(args, string(props))
+	args = amqpAppendLongString(args, "PLAIN AMQPLAIN")
+	return amqpAppendLongString(args, "en_US")
+}
+
+// amqpDecodeResponse extracts the username and password from a SASL response
+func amqpDecodeResponse(mechanism string, response string) (string, string) {
+	switch mechanism {
+	case "PLAIN":
+		parts := strings.SplitN(response, "\x00", 3)
+		if len(parts) == 3 {
+			return parts[1], parts[2]
+		}
+	case "AMQPLAIN":
+		table, _ := amqpDecodeTable([]byte(response))
+		return table["LOGIN"], table["PASSWORD"]
+	}
+	return "", ""
+}
+
+func amqpHandleConnection(c *ConfAMQP, conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	reader := bufio.NewReader(conn)
+
+	// Other protocol versions receive the supported header before the connection is closed
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return
+	}
+	if !bytes.Equal(header, amqpProtocolHeader) {
+		conn.Write(amqpProtocolHeader)
+		return
+	}
+
+	if err := amqpWriteMethod(conn, amqpClassConnection, amqpMethodStart, amqpStartArgs()); err != nil {
+		return
+	}
+
+	class, method, args, err := amqpReadMethod(reader)
+	if err != nil || class != amqpClassConnection || method != amqpMethodStartOk {
+		return
+	}
+
+	props, idx, err := amqpReadTable(args, 0)
+	if err != nil {
+		return
+	}
+	mechanism, idx, err := amqpReadShortString(args, idx)
+	if err != nil {
+		return
+	}
+	response, _, err := amqpReadLongString(args, idx)
+	if err != nil {
+		return
+	}
+
+	username, password := amqpDecodeResponse(mechanism, response)
+	rec := map[string]string{
+		"_server":  fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+		"method":   mechanism,
+		"username": username,
+		"password": password,
+	}
+	for k, v := range props {
+		rec["client_"+k] = v
+	}
+
+	// Tune the connection to learn the virtual host from Connection.Open
+	tune := []byte{}
+	tune = binary.BigEndian.AppendUint16(tune, 2047)
+	tune = binary.BigEndian.AppendUint32(tune, amqpMaxFrameSize)
+	tune = binary.BigEndian.AppendUint16(tune, 60)
+	if err := amqpWriteMethod(conn, amqpClassConnection, amqpMethodTune, tune); err == nil {
+		for {
+			class, method, args, err := amqpReadMethod(reader)
+			if err != nil || class != amqpClassConnection {
+				break
+			}
+			if method == amqpMethodTuneOk {
+				continue
+			}
+			if method == amqpMethodOpen {
+				rec["vhost"], _, _ = amqpReadShortString(args, 0)
+			}
+			break
+		}
+	}
+
+	c.RecordWriter.Record("credential", c.protoName(), conn.RemoteAddr().String(), rec)
+
+	closeArgs := binary.BigEndian.AppendUint16(nil, amqpReplyAccessRefused)
+	closeArgs = amqpAppendShortString(closeArgs, fmt.Sprintf("ACCESS_REFUSED - Login was refused using authentication mechanism %s. For details see the broker logfile.", mechanism))
+	closeArgs = binary.BigEndian.AppendUint16(closeArgs, 0)
+	closeArgs = binary.BigEndian.AppendUint16(closeArgs, 0)
+	amqpWriteMethod(conn, amqpClassConnection, amqpMethodClose, closeArgs)
+}
@@ -0,0 +1,366 @@
+package flamingo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	ber "github.com/atredispartners/flamingo/pkg/asn1-ber"
+	"github.com/atredispartners/flamingo/pkg/ldap"
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// cldapLogonResponseEx is the LOGON_SAM_LOGON_RESPONSE_EX operation code
+	cldapLogonResponseEx = 23
+
+	// cldapDCFlags advertises a writable 2016 PDC, GC, KDC, and time server with DNS names
+	cldapDCFlags = 0xe001f3fd
+
+	// cldapNtVersion* are the NtVer flags that select the netlogon response format
+	cldapNtVersion1         = 0x01
+	cldapNtVersion5EX       = 0x04
+	cldapNtVersion5EXWithIP = 0x08
+	cldapNtVersionWithSite  = 0x10
+)
+
+// ConfCLDAP describes the options for a connectionless LDAP netlogon responder
+type ConfCLDAP struct {
+	BindPort      uint16
+	BindHost      string
+	Domain        string
+	NetBIOSDomain string
+	HostName      string
+	SiteName      string
+	DomainGUID    string
+	DCAddress     string
+	RecordWriter  *RecordWriter
+	shutdown      bool
+	listener      *net.UDPConn
+	guid          []byte
+	m             sync.Mutex
+}
+
+// IsShutdown checks to see if the service is shutting down
+func (c *ConfCLDAP) IsShutdown() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.shutdown
+}
+
+// Shutdown flags the service to shut down
+func (c *ConfCLDAP) Shutdown() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.shutdown {
+		return
+	}
+	c.shutdown = true
+	c.listener.Close()
+}
+
+// NewConfCLDAP creates a default configuration for the CLDAP responder
+func NewConfCLDAP() *ConfCLDAP {
+	return &ConfCLDAP{
+		BindPort: 389,
+		BindHost: "[::]",
+		Domain:   "corp.local",
+		HostName: "dc01",
+		SiteName: "Default-First-Site-Name",
+	}
+}
+
+// SpawnCLDAP starts a CLDAP netlogon responder
+func SpawnCLDAP(c *ConfCLDAP) error {
+	c.Domain = strings.ToLower(strings.TrimSuffix(c.Domain, "."))
+	if c.Domain == "" {
+		return fmt.Errorf("a domain name is required for cldap")
+	}
+	if c.NetBIOSDomain == "" {
+		c.NetBIOSDomain = strings.SplitN(c.Domain, ".", 2)[0]
+	}
+	if c.HostName == "" {
+		c.HostName = "dc01"
+	}
+	if !strings.Contains(c.HostName, ".") {
+		c.HostName = c.HostName + "." + c.Domain
+	}
+	if c.DCAddress != "" && net.ParseIP(c.DCAddress) == nil {
+		return fmt.Errorf("invalid cldap dc address %s", c.DCAddress)
+	}
+
+	if c.DomainGUID == "" {
+		c.DomainGUID = cldapFormatGUID(cldapRandomGUID())
+	}
+	guid, err := cldapParseGUID(c.DomainGUID)
+	if err != nil {
+		return fmt.Errorf("invalid cldap domain guid %q", c.DomainGUID)
+	}
+	c.guid = guid
+
+	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort))
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s:%d (%s)", c.BindHost, c.BindPort, err)
+	}
+
+	listener, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s:%d (%s)", c.BindHost, c.BindPort, err)
+	}
+
+	c.listener = listener
+
+	go cldapStart(c)
+
+	return nil
+}
+
+func cldapStart(c *ConfCLDAP) {
+	log.Debugf("cldap is listening on %s:%d", c.BindHost, c.BindPort)
+
+	buff := make([]byte, 65535)
+	for {
+		if c.IsShutdown() {
+			log.Debugf("cldap server on %s:%d is shutting down", c.BindHost, c.BindPort)
+			break
+		}
+
+		rlen, raddr, rerr := c.listener.ReadFromUDP(buff)
+		if rerr != nil {
+			continue
+		}
+
+		data := buff[0:rlen]
+		cldapProcess(c, raddr, data)
+	}
+}
+
+func cldapProcess(c *ConfCLDAP, raddr *net.UDPAddr, data []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Debugf("cldap decoder panic with data %s: %q", hex.EncodeToString(data), r)
+		}
+	}()
+	cldapProcessData(c, raddr, data)
+}
+
+func cldapProcessData(c *ConfCLDAP, raddr *net.UDPAddr, data []byte) {
+	packet, err := ber.DecodePacket(data)
+	if err != nil || len(packet.Children) < 2 {
+		return
+	}
+
+	messageID, ok := packet.Children[0].Value.(uint64)
+	if !ok {
+		return
+	}
+
+	// Older clients include the RFC 1798 user name before the operation
+	req := packet.Children[1]
+	if req.ClassType != ber.ClassApplication && len(packet.Children) > 2 {
+		req = packet.Children[2]
+	}
+	if req.ClassType != ber.ClassApplication || req.Tag != ldap.ApplicationSearchRequest || len(req.Children) < 8 {
+		return
+	}
+
+	netlogon := false
+	for _, attr := range req.Children[7].Children {
+		if name, ok := attr.Value.(string); ok && strings.EqualFold(name, "netlogon") {
+			netlogon = true
+		}
+	}
+
+	out := []byte{}
+	if netlogon {
+		values, err := cldapFilterValues(req.Children[6])
+		if err != nil {
+			log.Debugf("cldap invalid filter from %s: %s", raddr.String(), err)
+			return
+		}
+
+		ntVer := uint32(0)
+		if len(values["ntver"]) == 4 {
+			ntVer = binary.LittleEndian.Uint32(values["ntver"])
+		}
+
+		rec := map[string]string{
+			"_server":    fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+			"nt_version": fmt.Sprintf("0x%08x", ntVer),
+		}
+		for k, field := range map[string]string{"dnsdomain": "dns_domain", "host": "host", "dnshostname": "dns_host_name", "user": "user"} {
+			if v, ok := values[k]; ok {
+				rec[field] = string(v)
+			}
+		}
+		if v, ok := values["aac"]; ok && len(v) == 4 {
+			rec["aac"] = fmt.Sprintf("0x%08x", binary.LittleEndian.Uint32(v))
+		}
+		if v, ok := values["domainsid"]; ok {
+			rec["domain_sid"] = cldapFormatSID(v)
+		}
+		if v, ok := values["domainguid"]; ok && len(v) == 16 {
+			rec["domain_guid"] = cldapFormatGUID(v)
+		}
+		c.RecordWriter.Record("access", "cldap", raddr.String(), rec)
+
+		resp := c.cldapLogonResponse(raddr, ntVer, string(values["user"]))
+		entry := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+		entry.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+		result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+		result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Object Name"))
+		attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "Netlogon", "Attribute Name"))
+		vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Attribute Values")
+		vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(resp), "Attribute Value"))
+		attr.AppendChild(vals)
+		attrs.AppendChild(attr)
+		result.AppendChild(attrs)
+		entry.AppendChild(result)
+		out = append(out, entry.Bytes()...)
+	}
+
+	// Domain controllers send the entry and the result in a single datagram
+	done := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	done.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultDone, nil, "Search Result Done")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, uint64(ldap.LDAPResultSuccess), "resultCode"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "errorMessage"))
+	done.AppendChild(result)
+	out = append(out, done.Bytes()...)
+
+	c.listener.WriteToUDP(out, raddr)
+}
+
+// cldapLogonResponse builds a NETLOGON_SAM_LOGON_RESPONSE_EX naming this sensor as the domain controller
+func (c *ConfCLDAP) cldapLogonResponse(raddr *net.UDPAddr, ntVer uint32, user string) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, uint16(cldapLogonResponseEx))
+	binary.Write(buf, binary.LittleEndian, uint16(0))
+	binary.Write(buf, binary.LittleEndian, uint32(cldapDCFlags))
+	buf.Write(c.guid)
+
+	netbiosHost := strings.ToUpper(strings.SplitN(c.HostName, ".", 2)[0])
+	for _, name := range []string{c.Domain, c.Domain, c.HostName, strings.ToUpper(c.NetBIOSDomain), netbiosHost, user, c.SiteName, c.SiteName} {
+		buf.Write(cldapPackName(name))
+	}
+
+	// The DC address is only included when requested, as a sockaddr_in structure
+	if ntVer&cldapNtVersion5EXWithIP != 0 {
+		opts := ResponderOptions{ResolveToIP: c.DCAddress}
+		if ip := opts.AnswerIP(raddr).To4(); ip != nil {
+			sockaddr := make([]byte, 16)
+			binary.LittleEndian.PutUint16(sockaddr[0:2], 2)
+			copy(sockaddr[4:8], ip)
+			buf.WriteByte(byte(len(sockaddr)))
+			buf.Write(sockaddr)
+		} else {
+			buf.WriteByte(0)
+		}
+	}
+
+	// There is no next closest site, but the field is expected when requested
+	if ntVer&cldapNtVersionWithSite != 0 {
+		buf.Write(cldapPackName(""))
+	}
+
+	binary.Write(buf, binary.LittleEndian, uint32(cldapNtVersion1|cldapNtVersion5EX))
+	binary.Write(buf, binary.LittleEndian, uint16(0xffff))
+	binary.Write(buf, binary.LittleEndian, uint16(0xffff))
+	return buf.Bytes()
+}
+
+// cldapFilterValues checks a netlogon ping filter with ldap.DecompileFilter and returns its
+// equality matches by lowercase attribute name. Windows nests each term in another AND, and
+// other clients may use OR, so values are collected from AND and OR branches at any depth.
+// Negated terms and presence tests do not carry a value and are skipped.
+func cldapFilterValues(p *ber.Packet) (map[string][]byte, error) {
+	if _, err := ldap.DecompileFilter(p); err != nil {
+		return nil, err
+	}
+	values := map[string][]byte{}
+	cldapCollectFilterValues(p, values)
+	return values, nil
+}
+
+func cldapCollectFilterValues(p *ber.Packet, values map[string][]byte) {
+	switch p.Tag {
+	case ldap.FilterAnd, ldap.FilterOr:
+		for _, child := range p.Children {
+			cldapCollectFilterValues(child, values)
+		}
+	case ldap.FilterEqualityMatch:
+		if len(p.Children) != 2 {
+			return
+		}
+		name := strings.ToLower(ber.DecodeString(p.Children[0].Data.Bytes()))
+		if _, ok := values[name]; !ok {
+			values[name] = p.Children[1].Data.Bytes()
+		}
+	}
+}
+
+// cldapPackName encodes a name as uncompressed RFC 1035 labels
+func cldapPackName(name string) []byte {
+	buf := make([]byte, 256)
+	off, err := dns.PackDomainName(dns.Fqdn(name), buf, 0, nil, false)
+	if err != nil {
+		return []byte{0}
+	}
+	return buf[:off]
+}
+
+// cldapRandomGUID returns a random version 4 GUID in its binary form
+func cldapRandomGUID() []byte {
+	guid, _ := hex.DecodeString(RandomHex(16))
+	guid[7] = (guid[7] & 0x0f) | 0x40
+	guid[8] = (guid[8] & 0x3f) | 0x80
+	return guid
+}
+
+// cldapParseGUID converts a GUID string to the mixed-endian binary form used by Windows
+func cldapParseGUID(s string) ([]byte, error) {
+	raw, err := hex.DecodeString(strings.Replace(strings.Trim(s, "{}"), "-", "", -1))
+	if err != nil || len(raw) != 16 {
+		return nil, fmt.Errorf("invalid guid")
+	}
+	guid := make([]byte, 16)
+	copy(guid, raw)
+	binary.LittleEndian.PutUint32(guid[0:4], binary.BigEndian.Uint32(raw[0:4]))
+	binary.LittleEndian.PutUint16(guid[4:6], binary.BigEndian.Uint16(raw[4:6]))
+	binary.LittleEndian.PutUint16(guid[6:8], binary.BigEndian.Uint16(raw[6:8]))
+	return guid, nil
+}
+
+// cldapFormatGUID converts a binary mixed-endian GUID to its string form
+func cldapFormatGUID(guid []byte) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%s-%s",
+		binary.LittleEndian.Uint32(guid[0:4]),
+		binary.LittleEndian.Uint16(guid[4:6]),
+		binary.LittleEndian.Uint16(guid[6:8]),
+		hex.EncodeToString(guid[8:10]),
+		hex.EncodeToString(guid[10:16]))
+}
+
+// cldapFormatSID converts a binary security identifier to its string form
+func cldapFormatSID(sid []byte) string {
+	if len(sid) < 8 || len(sid) < 8+int(sid[1])*4 {
+		return hex.EncodeToString(sid)
+	}
+	authority := uint64(0)
+	for _, b := range sid[2:8] {
+		authority = authority<<8 | uint64(b)
+	}
+	res := fmt.Sprintf("S-%d-%d", sid[0], authority)
+	for i := 0; i < int(sid[1]); i++ {
+		res += fmt.Sprintf("-%d", binary.LittleEndian.Uint32(sid[8+i*4:]))
+	}
+	return res
+}
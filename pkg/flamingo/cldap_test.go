@@ -0,0 +1,69 @@
+package flamingo
+
+import (
+	"reflect"
+	"testing"
+
+	ber "github.com/atredispartners/flamingo/pkg/asn1-ber"
+	"github.com/atredispartners/flamingo/pkg/ldap"
+)
+
+func TestCLDAPFilterValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		want   map[string]string
+	}{
+		{
+			// Windows nests each term of the DC locator ping in another AND
+			"windows",
+			"(&(&(&(&(DnsDomain=corp.local)(Host=WS01))(User=WS01$))(AAC=\x10\x00\x00\x00))(NtVer=\x16\x00\x00\x00))",
+			map[string]string{"dnsdomain": "corp.local", "host": "WS01", "user": "WS01$", "aac": "\x10\x00\x00\x00", "ntver": "\x16\x00\x00\x00"},
+		},
+		{
+			"and under or",
+			"(|(&(DnsDomain=corp.local)(NtVer=\x06\x00\x00\x00))(Host=WS02))",
+			map[string]string{"dnsdomain": "corp.local", "ntver": "\x06\x00\x00\x00", "host": "WS02"},
+		},
+		{
+			"presence and not",
+			"(&(DnsDomain=corp.local)(NtVer=*)(!(Host=WS03)))",
+			map[string]string{"dnsdomain": "corp.local"},
+		},
+	}
+
+	for _, tt := range tests {
+		compiled, err := ldap.CompileFilter(tt.filter)
+		if err != nil {
+			t.Fatalf("%s: failed to compile filter: %s", tt.name, err)
+		}
+
+		// Decode the filter as it arrives on the wire
+		p, err := ber.DecodePacket(compiled.Bytes())
+		if err != nil {
+			t.Fatalf("%s: failed to decode filter: %s", tt.name, err)
+		}
+		values, err := cldapFilterValues(p)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+
+		got := map[string]string{}
+		for k, v := range values {
+			got[k] = string(v)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// A NOT without a term is rejected by ldap.DecompileFilter
+	p, err := ber.DecodePacket(ber.Encode(ber.ClassContext, ber.TypeConstructed, ldap.FilterNot, nil, "Not").Bytes())
+	if err != nil {
+		t.Fatalf("failed to decode filter: %s", err)
+	}
+	if _, err := cldapFilterValues(p); err == nil {
+		t.Errorf("accepted an invalid filter")
+	}
+}
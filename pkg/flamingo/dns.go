@@ -125,14 +125,7 @@ func (c *ConfDNS) Shutdown() {
 // ServeDNS handles DNS requests
 func (c *ConfDNS) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
 	remoteAddr := w.RemoteAddr()
-	questions := []string{}
-	for _, q := range req.Question {
-		qtype := dnsTypeMap[q.Qtype]
-		if qtype == "" {
-			qtype = fmt.Sprintf("%d", q.Qtype)
-		}
-		questions = append(questions, fmt.Sprintf("%s/%s", qtype, q.Name))
-	}
+	questions := dnsFormatQuestions(req.Question)
 
 	if len(questions) > 0 {
 		c.RecordWriter.Record(
@@ -165,6 +158,19 @@ func (c *ConfDNS) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
 	w.WriteMsg(&m)
 }
 
+// dnsFormatQuestions describes each question as type/name for access records
+func dnsFormatQuestions(qs []dns.Question) []string {
+	questions := []string{}
+	for _, q := range qs {
+		qtype := dnsTypeMap[q.Qtype]
+		if qtype == "" {
+			qtype = fmt.Sprintf("%d", q.Qtype)
+		}
+		questions = append(questions, fmt.Sprintf("%s/%s", qtype, q.Name))
+	}
+	return questions
+}
+
 // NewConfDNS creates a default configuration for the DNS capture server.
 func NewConfDNS() *ConfDNS {
 	return &ConfDNS{
@@ -2,10 +2,12 @@ package flamingo
 
 import (
 	"bufio"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"strings"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -15,8 +17,13 @@ type ConfFTP struct {
 	BindPort     uint16
 	BindHost     string
 	RecordWriter *RecordWriter
+	TLS          bool
+	TLSName      string
+	TLSCert      string
+	TLSKey       string
 	shutdown     bool
 	listener     net.Listener
+	tlsConfig    *tls.Config
 	m            sync.Mutex
 }
 
@@ -39,22 +46,50 @@ func (c *ConfFTP) IsShutdown() bool {
 func (c *ConfFTP) Shutdown() {
 	c.m.Lock()
 	defer c.m.Unlock()
+	c.shutdown = true
 	c.listener.Close()
 }
 
-// SpawnFTP creates a new FTP capture server.
+func (c *ConfFTP) protoName() string {
+	if c.TLS {
+		return "ftps"
+	}
+	return "ftp"
+}
+
+// SpawnFTP creates a new FTP capture server, using implicit TLS if configured.
 func SpawnFTP(c *ConfFTP) error {
+
+	// Normal listeners support AUTH TLS when a certificate is available
+	if c.TLS || (c.TLSCert != "" && c.TLSKey != "") {
+		kp, err := tls.X509KeyPair([]byte(c.TLSCert), []byte(c.TLSKey))
+		if err != nil {
+			return fmt.Errorf("failed to load tls cert for %s on %s:%d (%s)", c.protoName(), c.BindHost, c.BindPort, err)
+		}
+		c.tlsConfig = &tls.Config{ServerName: c.TLSName, Certificates: []tls.Certificate{kp}}
+	}
+
+	if c.TLS {
+		listener, err := tls.Listen("tcp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort), c.tlsConfig)
+		if err != nil {
+			return fmt.Errorf("failed to listen with tls on %s:%d (%s)", c.BindHost, c.BindPort, err)
+		}
+		c.listener = listener
+		go ftpStart(c)
+		return nil
+	}
+
 	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort))
 	if err != nil {
 		return err
 	}
-	log.Debugf("ftp is listening on %s:%d", c.BindHost, c.BindPort)
 	c.listener = listener
 	go ftpStart(c)
 	return nil
 }
 
 func ftpStart(c *ConfFTP) {
+	log.Debugf("%s is listening on %s:%d", c.protoName(), c.BindHost, c.BindPort)
 	for !c.IsShutdown() {
 		conn, err := c.listener.Accept()
 		if err != nil {
@@ -62,61 +97,214 @@ func ftpStart(c *ConfFTP) {
 		}
 		go ftpHandleConnection(c, conn)
 	}
+	log.Debugf("%s server on %s:%d is shutting down", c.protoName(), c.BindHost, c.BindPort)
 }
 
 func ftpCreateMessage(code int, msg string) string {
 	return fmt.Sprintf("%d %s\r\n", code, msg)
 }
 
+// ftpSession tracks the state of a FTP control connection
+type ftpSession struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	secure   bool
+	username string
+	loggedIn bool
+	client   string
+	commands []string
+}
+
+func (s *ftpSession) reply(code int, msg string) error {
+	_, err := s.conn.Write([]byte(ftpCreateMessage(code, msg)))
+	return err
+}
+
+// ftpFeatures returns the FEAT response advertised to clients
+func ftpFeatures(c *ConfFTP, s *ftpSession) string {
+	feats := []string{"211-Features:"}
+	if c.tlsConfig != nil && !s.secure {
+		feats = append(feats, " AUTH TLS")
+	}
+	if c.tlsConfig != nil {
+		feats = append(feats, " PBSZ", " PROT")
+	}
+	feats = append(feats, " CLNT", " EPRT", " EPSV", " MDTM", " PASV", " REST STREAM", " SIZE", " TVFS", " UTF8", "211 End")
+	return strings.Join(feats, "\r\n") + "\r\n"
+}
+
 func ftpHandleConnection(c *ConfFTP, conn net.Conn) {
-	defer conn.Close()
-	reader := bufio.NewReader(conn)
-	writer := bufio.NewWriter(conn)
-
-	writer.WriteString(ftpCreateMessage(220, "Welcome to FTP server."))
-	writer.Flush()
-	var (
-		username string
-		password string
-	)
+	s := &ftpSession{conn: conn, reader: bufio.NewReader(conn), secure: c.TLS}
+	defer func() { s.conn.Close() }()
+
+	if err := s.reply(220, "Welcome to FTP server."); err != nil {
+		return
+	}
+
 	for {
-		line, err := reader.ReadString('\n')
+		s.conn.SetDeadline(time.Now().Add(60 * time.Second))
+		line, err := s.reader.ReadString('\n')
 		if err != nil {
 			return
 		}
-		parts := strings.SplitN(strings.Trim(line, "\r\n"), " ", 2)
-		if len(parts) < 2 {
-			return
+		line = strings.TrimRight(line, "\r\n")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		// Keep everything after the command, since passwords may contain spaces
+		parts := strings.SplitN(line, " ", 2)
+		command := strings.ToUpper(strings.TrimSpace(parts[0]))
+		arg := ""
+		if len(parts) == 2 {
+			arg = parts[1]
+		}
+
+		// The commands sent before logging in identify the client software
+		if !s.loggedIn && len(s.commands) < 32 {
+			s.commands = append(s.commands, command)
 		}
-		command := parts[0]
-		msg := parts[1]
+
 		switch command {
 		case "USER":
-			username = msg
-			writer.WriteString(ftpCreateMessage(331, "Username ok, password required"))
-			writer.Flush()
+			if arg == "" {
+				s.reply(501, "Syntax error in parameters or arguments.")
+				continue
+			}
+			s.username = arg
+			s.loggedIn = false
+			s.reply(331, "Username ok, password required")
+
 		case "PASS":
-			password = msg
-			writer.WriteString(ftpCreateMessage(230, "Password ok, continue"))
-			writer.Flush()
-		default:
-			writer.WriteString(ftpCreateMessage(500, "Command not found"))
-			writer.Flush()
-			break
-		}
-		if username != "" && password != "" {
-			c.RecordWriter.Record(
-				"credential",
-				"ftp",
-				conn.RemoteAddr().String(),
-				map[string]string{
-					"username": username,
-					"password": password,
-					"_server":  fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
-				},
-			)
+			if s.username == "" {
+				s.reply(503, "Login with USER first.")
+				continue
+			}
+			ftpRecord(c, s, arg)
+			s.commands = nil
+			s.loggedIn = true
+			s.reply(230, "Password ok, continue")
+
+		case "AUTH":
+			switch {
+			case s.secure:
+				s.reply(503, "Already using TLS.")
+			case c.tlsConfig == nil:
+				s.reply(502, "Command not implemented.")
+			case strings.ToUpper(strings.TrimSpace(arg)) != "TLS" && strings.ToUpper(strings.TrimSpace(arg)) != "SSL":
+				s.reply(504, "Unknown AUTH type.")
+			default:
+				if err := s.reply(234, "Proceed with negotiation."); err != nil {
+					return
+				}
+				tlsConn := tls.Server(s.conn, c.tlsConfig)
+				if err := tlsConn.Handshake(); err != nil {
+					log.Debugf("ftp failed to start tls with %s: %s", s.conn.RemoteAddr().String(), err)
+					return
+				}
+				s.conn = tlsConn
+				s.reader = bufio.NewReader(tlsConn)
+				s.secure = true
+			}
+
+		case "PBSZ":
+			if !s.secure {
+				s.reply(503, "PBSZ needs a secure connection.")
+				continue
+			}
+			s.reply(200, "PBSZ=0")
+
+		case "PROT":
+			switch {
+			case !s.secure:
+				s.reply(503, "PROT needs a secure connection.")
+			case strings.ToUpper(strings.TrimSpace(arg)) == "P":
+				s.reply(200, "PROT now Private.")
+			case strings.ToUpper(strings.TrimSpace(arg)) == "C":
+				s.reply(200, "PROT now Clear.")
+			default:
+				s.reply(536, "PROT level not supported.")
+			}
+
+		case "FEAT":
+			if _, err := s.conn.Write([]byte(ftpFeatures(c, s))); err != nil {
+				return
+			}
+
+		case "SYST":
+			s.reply(215, "UNIX Type: L8")
+
+		case "OPTS":
+			if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(arg)), "UTF8") {
+				s.reply(200, "Always in UTF8 mode.")
+				continue
+			}
+			s.reply(501, "Option not understood.")
+
+		case "CLNT":
+			s.client = arg
+			s.reply(200, "Noted.")
+
+		case "NOOP":
+			s.reply(200, "NOOP ok.")
+
+		case "QUIT":
+			s.reply(221, "Goodbye.")
 			return
+
+		case "PWD", "XPWD":
+			if !s.loggedIn {
+				s.reply(530, "Please login with USER and PASS.")
+				continue
+			}
+			s.reply(257, `"/" is the current directory`)
+
+		case "TYPE":
+			switch {
+			case !s.loggedIn:
+				s.reply(530, "Please login with USER and PASS.")
+			case strings.HasPrefix(strings.ToUpper(arg), "A"):
+				s.reply(200, "Switching to ASCII mode.")
+			case strings.HasPrefix(strings.ToUpper(arg), "I"):
+				s.reply(200, "Switching to Binary mode.")
+			default:
+				s.reply(500, "Unrecognised TYPE command.")
+			}
+
+		case "CWD", "XCWD", "CDUP", "XCUP":
+			if !s.loggedIn {
+				s.reply(530, "Please login with USER and PASS.")
+				continue
+			}
+			s.reply(250, "Directory successfully changed.")
+
+		case "PASV", "EPSV", "PORT", "EPRT", "LIST", "NLST", "MLSD", "MLST", "RETR", "STOR", "APPE",
+			"DELE", "MKD", "RMD", "RNFR", "RNTO", "SIZE", "MDTM", "REST", "STAT", "SITE":
+			if !s.loggedIn {
+				s.reply(530, "Please login with USER and PASS.")
+				continue
+			}
+			s.reply(550, "Permission denied.")
+
+		default:
+			s.reply(500, "Unknown command.")
 		}
 	}
+}
 
+// ftpRecord writes a credential record with the client identity seen so far
+func ftpRecord(c *ConfFTP, s *ftpSession, password string) {
+	rec := map[string]string{
+		"username": s.username,
+		"password": password,
+		"commands": strings.Join(s.commands, ","),
+		"_server":  fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+	}
+	if s.client != "" {
+		rec["client"] = s.client
+	}
+	if s.secure {
+		rec["tls"] = "true"
+	}
+	c.RecordWriter.Record("credential", c.protoName(), s.conn.RemoteAddr().String(), rec)
 }
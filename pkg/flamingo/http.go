@@ -28,6 +28,9 @@ type ConfHTTP struct {
 	BindHost     string
 	BasicRealm   string
 	AuthMode     string
+	Proxy        bool
+	WPADProxy    string
+	WPADAuth     string
 	RecordWriter *RecordWriter
 	TLS          bool
 	TLSName      string
@@ -75,6 +78,9 @@ func SpawnHTTP(c *ConfHTTP) error {
 		Addr:         fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
 	}
 	c.server.Handler = httpHandler(c)
+	if c.WPADProxy != "" && !c.Proxy {
+		c.server.Handler = httpWPADHandler(c, httpHandler(c))
+	}
 
 	// Handler normal listeners
 	if !c.TLS {
@@ -106,11 +112,7 @@ func SpawnHTTP(c *ConfHTTP) error {
 }
 
 func startHTTP(c *ConfHTTP) {
-	pname := "http"
-	if c.TLS {
-		pname = "https"
-	}
-	log.Debugf("%s is listening on %s:%d", pname, c.BindHost, c.BindPort)
+	log.Debugf("%s is listening on %s:%d", c.protoName(), c.BindHost, c.BindPort)
 	err := c.server.Serve(c.listener)
 	if err != nil {
 		log.Debugf("http server exited with error %s", err)
@@ -119,42 +121,266 @@ func startHTTP(c *ConfHTTP) {
 	return
 }
 
+func (c *ConfHTTP) protoName() string {
+	switch {
+	case c.Proxy:
+		return "proxy"
+	case c.TLS:
+		return "https"
+	}
+	return "http"
+}
+
+// authHeader returns the name of the request header carrying credentials
+func (c *ConfHTTP) authHeader() string {
+	if c.Proxy {
+		return "Proxy-Authorization"
+	}
+	return "Authorization"
+}
+
+// challengeHeader returns the name of the response header carrying the auth challenge
+func (c *ConfHTTP) challengeHeader() string {
+	if c.Proxy {
+		return "Proxy-Authenticate"
+	}
+	return "WWW-Authenticate"
+}
+
+// challengeStatus returns the status code used to request authentication
+func (c *ConfHTTP) challengeStatus() int {
+	if c.Proxy {
+		return http.StatusProxyAuthRequired
+	}
+	return http.StatusUnauthorized
+}
+
+// httpRequestURL returns the URL requested by the client, which is the target for proxy requests
+func httpRequestURL(c *ConfHTTP, r *http.Request) string {
+	if c.Proxy {
+		return r.RequestURI
+	}
+	return fmt.Sprintf("%s://%s%s", c.protoName(), r.Host, r.RequestURI)
+}
+
 func httpHandler(c *ConfHTTP) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		switch c.AuthMode {
-		case "ntlm":
-			if httpHandleNTLMAuth(c, w, r) {
+		if c.Proxy {
+			if httpHandleProxyAuth(c, w, r) {
 				return
 			}
-		case "basic":
-			if httpHandleBasicAuth(c, w, r) {
-				return
+		} else {
+			switch c.AuthMode {
+			case "ntlm":
+				if httpHandleNTLMAuth(c, w, r) {
+					return
+				}
+			case "basic":
+				if httpHandleBasicAuth(c, w, r) {
+					return
+				}
+			case "registry":
+				if httpHandleRegistryAuth(c, w, r) {
+					return
+				}
 			}
 		}
 
-		pname := "http"
-		if c.TLS {
-			pname = "https"
-		}
-
 		c.RecordWriter.Record(
 			"access",
-			pname,
+			c.protoName(),
 			r.RemoteAddr,
 			map[string]string{
 				"_server":       fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
 				"agent":         r.UserAgent(),
 				"path":          r.RequestURI,
-				"url":           fmt.Sprintf("%s://%s%s", pname, r.Host, r.RequestURI),
-				"authorization": r.Header.Get("Authorization"),
+				"url":           httpRequestURL(c, r),
+				"authorization": r.Header.Get(c.authHeader()),
 			},
 		)
 	}
 }
 
+// httpHandleProxyAuth offers both NTLM and Basic authentication to proxy clients
+func httpHandleProxyAuth(c *ConfHTTP, w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Server", "squid/3.5.27")
+	w.Header().Set("Proxy-Connection", "Keep-Alive")
+
+	auth := strings.ToLower(strings.TrimSpace(r.Header.Get(c.authHeader())))
+	switch {
+	case strings.HasPrefix(auth, "basic "):
+		if httpHandleBasicAuth(c, w, r) {
+			w.WriteHeader(http.StatusForbidden)
+			return true
+		}
+	case strings.HasPrefix(auth, "ntlm "), strings.HasPrefix(auth, "negotiate "):
+		if httpHandleNTLMAuth(c, w, r) {
+			w.WriteHeader(http.StatusForbidden)
+			return true
+		}
+		return false
+	}
+
+	w.Header().Add(c.challengeHeader(), "Negotiate")
+	w.Header().Add(c.challengeHeader(), "NTLM")
+	w.Header().Add(c.challengeHeader(), fmt.Sprintf("Basic realm=%q", c.BasicRealm))
+	w.WriteHeader(c.challengeStatus())
+	return false
+}
+
+// httpWPADHandler serves a PAC file for WPAD paths and passes everything else to next
+func httpWPADHandler(c *ConfHTTP, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch strings.ToLower(r.URL.Path) {
+		case "/wpad.dat", "/proxy.pac":
+		default:
+			next(w, r)
+			return
+		}
+
+		switch c.WPADAuth {
+		case "ntlm":
+			if !httpHandleNTLMAuth(c, w, r) {
+				return
+			}
+		case "basic":
+			if !httpHandleBasicAuth(c, w, r) {
+				w.Header().Set(c.challengeHeader(), fmt.Sprintf("Basic realm=%q", c.BasicRealm))
+				w.WriteHeader(c.challengeStatus())
+				return
+			}
+		}
+
+		c.RecordWriter.Record(
+			"access",
+			c.protoName(),
+			r.RemoteAddr,
+			map[string]string{
+				"_server": fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+				"agent":   r.UserAgent(),
+				"path":    r.RequestURI,
+				"url":     httpRequestURL(c, r),
+				"wpad":    c.WPADProxy,
+			},
+		)
+
+		w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+		w.Write([]byte(httpWPADScript(c.WPADProxy)))
+	}
+}
+
+// httpWPADScript generates a PAC file that sends everything but loopback traffic to the proxy
+func httpWPADScript(proxy string) string {
+	return "function FindProxyForURL(url, host) {\n" +
+		"  if (dnsDomainIs(host, \"localhost\") || host == \"127.0.0.1\") {\n" +
+		"    return \"DIRECT\";\n" +
+		"  }\n" +
+		fmt.Sprintf("  return \"PROXY %s; DIRECT\";\n", proxy) +
+		"}\n"
+}
+
+// httpRegistryTokenPath is the token service path advertised in registry challenges
+const httpRegistryTokenPath = "/token"
+
+// httpHandleRegistryAuth emulates the container registry v2 API and its token service
+func httpHandleRegistryAuth(c *ConfHTTP, w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.URL.Path == httpRegistryTokenPath {
+		return httpHandleRegistryToken(c, w, r)
+	}
+
+	// Clients with a cached token present it directly to the registry
+	meta := map[string]string{
+		"_server": fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+		"agent":   r.UserAgent(),
+		"path":    r.RequestURI,
+		"url":     httpRequestURL(c, r),
+	}
+	bits := strings.SplitN(strings.TrimSpace(r.Header.Get("Authorization")), " ", 2)
+	recorded := false
+	if len(bits) == 2 && strings.ToLower(bits[0]) == "bearer" && bits[1] != "" {
+		meta["method"] = "bearer"
+		meta["token"] = bits[1]
+		c.RecordWriter.Record("credential", c.protoName(), r.RemoteAddr, meta)
+		recorded = true
+	}
+
+	challenge := fmt.Sprintf("Bearer realm=\"%s://%s%s\",service=%q", c.protoName(), r.Host, httpRegistryTokenPath, r.Host)
+	if scope := httpRegistryScope(r); scope != "" {
+		challenge += fmt.Sprintf(",scope=%q", scope)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write([]byte(`{"errors":[{"code":"UNAUTHORIZED","message":"authentication required","detail":null}]}` + "\n"))
+	return recorded
+}
+
+// httpHandleRegistryToken records Basic and OAuth2 credentials sent to the token service
+func httpHandleRegistryToken(c *ConfHTTP, w http.ResponseWriter, r *http.Request) bool {
+	meta := map[string]string{
+		"_server": fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+		"agent":   r.UserAgent(),
+		"path":    r.RequestURI,
+		"url":     httpRequestURL(c, r),
+	}
+
+	r.ParseForm()
+	meta["scope"] = strings.Join(r.Form["scope"], " ")
+	meta["service"] = r.Form.Get("service")
+
+	recorded := false
+	if username, password, ok := r.BasicAuth(); ok {
+		meta["method"] = "basic"
+		meta["username"] = username
+		meta["password"] = password
+		recorded = true
+	} else {
+		switch r.PostForm.Get("grant_type") {
+		case "password":
+			meta["method"] = "oauth2"
+			meta["username"] = r.PostForm.Get("username")
+			meta["password"] = r.PostForm.Get("password")
+			recorded = true
+		case "refresh_token":
+			meta["method"] = "refresh_token"
+			meta["username"] = r.PostForm.Get("username")
+			meta["token"] = r.PostForm.Get("refresh_token")
+			recorded = true
+		}
+	}
+
+	if recorded {
+		c.RecordWriter.Record("credential", c.protoName(), r.RemoteAddr, meta)
+	}
+
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write([]byte(`{"details":"incorrect username or password"}` + "\n"))
+	return recorded
+}
+
+// httpRegistryScope derives the repository scope for a registry API path
+func httpRegistryScope(r *http.Request) string {
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	if path == r.URL.Path {
+		return ""
+	}
+	for _, kind := range []string{"/manifests/", "/blobs/", "/tags/"} {
+		if idx := strings.Index(path, kind); idx > 0 {
+			actions := "pull"
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				actions = "pull,push"
+			}
+			return fmt.Sprintf("repository:%s:%s", path[:idx], actions)
+		}
+	}
+	return ""
+}
+
 func httpHandleBasicAuth(c *ConfHTTP, w http.ResponseWriter, r *http.Request) bool {
-	auth := strings.TrimSpace(r.Header.Get("Authorization"))
+	auth := strings.TrimSpace(r.Header.Get(c.authHeader()))
 	if len(auth) == 0 {
 		return false
 	}
@@ -179,20 +405,15 @@ func httpHandleBasicAuth(c *ConfHTTP, w http.ResponseWriter, r *http.Request) bo
 		return false
 	}
 
-	pname := "http"
-	if c.TLS {
-		pname = "https"
-	}
-
 	c.RecordWriter.Record(
 		"credential",
-		pname,
+		c.protoName(),
 		r.RemoteAddr,
 		map[string]string{
 			"_server":  fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
 			"agent":    r.UserAgent(),
 			"path":     r.RequestURI,
-			"url":      fmt.Sprintf("%s://%s%s", pname, r.Host, r.RequestURI),
+			"url":      httpRequestURL(c, r),
 			"username": bits[0],
 			"password": bits[1],
 			"method":   "basic",
@@ -211,31 +432,55 @@ func httpHandleNTLMAuth(c *ConfHTTP, w http.ResponseWriter, r *http.Request) (ok
 	}
 
 	for k, v := range headers {
+		if w.Header().Get(k) != "" {
+			continue
+		}
 		w.Header().Set(k, v)
 	}
 
-	if r.Method == "OPTIONS" {
+	if r.Method == "OPTIONS" && !c.Proxy {
 		// OPTIONS indicates a possible WebDAV client
 		w.Header().Set("Allow", "OPTIONS,GET,HEAD,POST,PUT,DELETE,TRACE,"+
 			"PROPFIND,PROPPATCH,MKCOL,COPY,MOVE,LOCK,UNLOCK")
 
-	} else if r.Method == "GET" || r.Method == "PROPFIND" {
-		// GETs for standard HTTP client or PROPFIND for stage 2 WebDAV
+	} else if c.Proxy || r.Method == "GET" || r.Method == "PROPFIND" {
+		// GETs for standard HTTP client or PROPFIND for stage 2 WebDAV,
+		// while proxy clients authenticate with any method, including CONNECT
 
-		authHeader := ""
-		if r.Header["Authorization"] != nil {
-			authHeader = r.Header["Authorization"][0]
+		authHeader := r.Header.Get(c.authHeader())
+		scheme, token, err := ntlmHeaderToken(authHeader)
+		if err != nil {
+			w.WriteHeader(404)
+			return
+		}
+
+		// SPNEGO tokens without a NTLMSSP message (Kerberos) must be steered to NTLMSSP first
+		if token.Wrapped && token.NTLM == nil {
+			if !token.OfferedNTLM {
+				w.WriteHeader(404)
+				return
+			}
+			negTokenResp := spnegoWrapResponse(spnegoStateAcceptIncomplete, nil)
+			w.Header().Set(c.challengeHeader(), fmt.Sprintf("%s %s", scheme, base64.StdEncoding.EncodeToString(negTokenResp)))
+			w.WriteHeader(c.challengeStatus())
+			return
 		}
 
 		switch ntlmType(authHeader) {
 		case -1:
 			w.WriteHeader(404)
 		case 0:
-			w.Header().Set("WWW-Authenticate", "NTLM")
-			w.WriteHeader(401)
+			w.Header().Add(c.challengeHeader(), "Negotiate")
+			w.Header().Add(c.challengeHeader(), "NTLM")
+			w.WriteHeader(c.challengeStatus())
 		case 1:
-			w.Header().Set("WWW-Authenticate", fmt.Sprintf("NTLM %s", NTLMChallenge))
-			w.WriteHeader(401)
+			challenge := NTLMChallenge
+			if token.Wrapped {
+				raw, _ := base64.StdEncoding.DecodeString(NTLMChallenge)
+				challenge = base64.StdEncoding.EncodeToString(spnegoWrapResponse(spnegoStateAcceptIncomplete, raw))
+			}
+			w.Header().Set(c.challengeHeader(), fmt.Sprintf("%s %s", scheme, challenge))
+			w.WriteHeader(c.challengeStatus())
 		case 3:
 			ntlmBytes, err := ntlmHeaderBytes(authHeader)
 			if err != nil {
@@ -249,22 +494,19 @@ func httpHandleNTLMAuth(c *ConfHTTP, w http.ResponseWriter, r *http.Request) (ok
 				return
 			}
 
-			pname := "http"
-			if c.TLS {
-				pname = "https"
-			}
 			c.RecordWriter.Record(
 				"credential",
-				pname,
+				c.protoName(),
 				r.RemoteAddr,
 				map[string]string{
 					"_server":  fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
 					"agent":    r.UserAgent(),
 					"path":     r.RequestURI,
-					"url":      fmt.Sprintf("%s://%s%s", pname, r.Host, r.RequestURI),
+					"url":      httpRequestURL(c, r),
 					"username": netNTLMResponse.UserName.String(),
 					"hashcat":  ntlmToHashcat(netNTLMResponse, hashType),
 					"method":   "NTLMSSP",
+					"scheme":   scheme,
 				},
 			)
 			ok = true
@@ -282,7 +524,12 @@ func ntlmGetHashType(header string) int {
 		return -1
 	}
 
-	hashSize := netNTLMMessageBytes[22]
+	return ntlmMessageHashType(netNTLMMessageBytes)
+}
+
+// ntlmMessageHashType returns 1 for NetNTLMv1 and 2 for NetNTLMv2 authenticate messages
+func ntlmMessageHashType(msg []byte) int {
+	hashSize := msg[22]
 	if hashSize == 24 {
 		return 1
 	}
@@ -329,11 +576,37 @@ func ntlmToHashcat(h *ntlm.AuthenticateMessage, ntlmVer int) (out string) {
 	return
 }
 
+// ntlmHeaderToken decodes a NTLM or Negotiate authorization header, unwrapping SPNEGO if needed
+func ntlmHeaderToken(header string) (string, *spnegoToken, error) {
+	bits := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	scheme := bits[0]
+	b64 := ""
+	if len(bits) == 2 {
+		b64 = strings.TrimSpace(bits[1])
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return scheme, nil, err
+	}
+
+	switch strings.ToLower(scheme) {
+	case "", "ntlm":
+		return scheme, &spnegoToken{NTLM: raw, OfferedNTLM: true}, nil
+	case "negotiate":
+		if len(raw) == 0 {
+			return scheme, &spnegoToken{}, nil
+		}
+		token, err := spnegoUnwrap(raw)
+		return scheme, token, err
+	}
+	return scheme, nil, fmt.Errorf("unsupported authorization scheme %s", scheme)
+}
+
 func ntlmHeaderBytes(header string) ([]byte, error) {
-	b64 := strings.TrimPrefix(header, "NTLM ")
-	netNTLMMessageBytes, err := base64.StdEncoding.DecodeString(b64)
+	_, token, err := ntlmHeaderToken(header)
 	if err != nil {
-		return netNTLMMessageBytes, err
+		return nil, err
 	}
-	return netNTLMMessageBytes, err
+	return token.NTLM, nil
 }
@@ -0,0 +1,434 @@
+package flamingo
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ipmiPayloadMessage      = 0x00
+	ipmiPayloadOpenRequest  = 0x10
+	ipmiPayloadOpenResponse = 0x11
+	ipmiPayloadRAKP1        = 0x12
+	ipmiPayloadRAKP2        = 0x13
+	ipmiPayloadRAKP3        = 0x14
+	ipmiPayloadRAKP4        = 0x15
+
+	ipmiAuthTypeRMCPPlus = 0x06
+
+	ipmiCmdGetChannelAuthCap      = 0x38
+	ipmiCmdCloseSession           = 0x3c
+	ipmiCmdGetChannelCipherSuites = 0x54
+
+	// ipmiHashcatMinSalt is the shortest salt accepted by hashcat mode 7300
+	ipmiHashcatMinSalt = 32
+
+	// ipmiSessionTimeout limits how long RAKP session state is retained
+	ipmiSessionTimeout = 60 * time.Second
+)
+
+var ipmiAuthAlgNames = map[byte]string{
+	0x00: "none",
+	0x01: "hmac-sha1",
+	0x02: "hmac-md5",
+	0x03: "hmac-sha256",
+}
+
+// ConfIPMI describes the options for an IPMI service
+type ConfIPMI struct {
+	BindPort     uint16
+	BindHost     string
+	GUID         []byte
+	RecordWriter *RecordWriter
+	shutdown     bool
+	listener     net.PacketConn
+	sessions     map[uint32]*ipmiSession
+	sessionsLock sync.Mutex
+	m            sync.Mutex
+}
+
+// ipmiSession tracks the RAKP exchange for a remote console
+type ipmiSession struct {
+	ConsoleSessionID []byte
+	SystemSessionID  []byte
+	ConsoleRandom    []byte
+	SystemRandom     []byte
+	AuthAlg          byte
+	Role             byte
+	Username         []byte
+	Created          time.Time
+}
+
+// IsShutdown checks to see if the service is shutting down
+func (c *ConfIPMI) IsShutdown() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.shutdown
+}
+
+// Shutdown flags the service to shut down
+func (c *ConfIPMI) Shutdown() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.shutdown {
+		return
+	}
+	c.shutdown = true
+	c.listener.Close()
+}
+
+// NewConfIPMI creates a default configuration for the IPMI capture server
+func NewConfIPMI() *ConfIPMI {
+	guid := make([]byte, 16)
+	rand.Read(guid)
+	return &ConfIPMI{
+		BindPort: 623,
+		BindHost: "[::]",
+		GUID:     guid,
+		sessions: make(map[uint32]*ipmiSession),
+	}
+}
+
+// SpawnIPMI starts a logging IPMI server
+func SpawnIPMI(c *ConfIPMI) error {
+	if len(c.GUID) != 16 {
+		return fmt.Errorf("invalid system guid length %d", len(c.GUID))
+	}
+
+	// Create the UDP listener
+	listener, err := net.ListenPacket("udp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s:%d (%s)", c.BindHost, c.BindPort, err)
+	}
+
+	// Track the socket
+	c.listener = listener
+
+	// Start the ipmi handler
+	go ipmiStart(c)
+
+	return nil
+}
+
+func ipmiStart(c *ConfIPMI) {
+	log.Debugf("ipmi is listening on %s:%d", c.BindHost, c.BindPort)
+
+	buff := make([]byte, 4096)
+	for {
+		if c.IsShutdown() {
+			log.Debugf("ipmi server on %s:%d is shutting down", c.BindHost, c.BindPort)
+			break
+		}
+
+		rlen, raddr, rerr := c.listener.ReadFrom(buff)
+		if rerr != nil {
+			continue
+		}
+
+		data := buff[0:rlen]
+		ipmiProcess(c, raddr, data)
+	}
+}
+
+func ipmiProcess(c *ConfIPMI, raddr net.Addr, data []byte) {
+	resp := ipmiProcessData(c, raddr, data)
+	if resp != nil {
+		c.listener.WriteTo(resp, raddr)
+	}
+}
+
+func ipmiProcessData(c *ConfIPMI, raddr net.Addr, data []byte) []byte {
+	// RMCP header: version 6, reserved, sequence, class IPMI
+	if len(data) < 6 || data[0] != 0x06 || data[3]&0x1f != 0x07 {
+		return nil
+	}
+	body := data[4:]
+
+	// IPMI v1.5 session wrapper
+	if body[0] != ipmiAuthTypeRMCPPlus {
+		idx := 9
+		if body[0] != 0x00 {
+			idx += 16
+		}
+		if len(body) <= idx {
+			log.Debugf("ipmi v1.5 session header too short from %s: %s", raddr.String(), hex.EncodeToString(data))
+			return nil
+		}
+		mlen := int(body[idx])
+		if len(body) < idx+1+mlen {
+			log.Debugf("ipmi v1.5 message too short from %s: %s", raddr.String(), hex.EncodeToString(data))
+			return nil
+		}
+		msg := body[idx+1 : idx+1+mlen]
+
+		resp := ipmiHandleMessage(msg)
+		if resp == nil {
+			return nil
+		}
+
+		out := []byte{0x06, 0x00, 0xff, 0x07, 0x00, 0, 0, 0, 0, 0, 0, 0, 0, byte(len(resp))}
+		return append(out, resp...)
+	}
+
+	// IPMI v2.0 (RMCP+) session wrapper
+	ptype := body[1] & 0x3f
+	idx := 2
+	if ptype == 0x02 {
+		// OEM IANA and payload ID
+		idx += 6
+	}
+	idx += 8
+	if len(body) < idx+2 {
+		log.Debugf("ipmi v2.0 session header too short from %s: %s", raddr.String(), hex.EncodeToString(data))
+		return nil
+	}
+	plen := int(binary.LittleEndian.Uint16(body[idx:]))
+	idx += 2
+	if len(body) < idx+plen {
+		log.Debugf("ipmi v2.0 payload too short from %s: %s", raddr.String(), hex.EncodeToString(data))
+		return nil
+	}
+	payload := body[idx : idx+plen]
+
+	var rtype byte
+	var resp []byte
+	switch ptype {
+	case ipmiPayloadMessage:
+		rtype = ipmiPayloadMessage
+		resp = ipmiHandleMessage(payload)
+	case ipmiPayloadOpenRequest:
+		rtype = ipmiPayloadOpenResponse
+		resp = ipmiHandleOpenSession(c, payload)
+	case ipmiPayloadRAKP1:
+		rtype = ipmiPayloadRAKP2
+		resp = ipmiHandleRAKP1(c, raddr, payload)
+	case ipmiPayloadRAKP3:
+		rtype = ipmiPayloadRAKP4
+		resp = ipmiHandleRAKP3(c, raddr, payload)
+	}
+
+	if resp == nil {
+		return nil
+	}
+
+	out := []byte{0x06, 0x00, 0xff, 0x07, ipmiAuthTypeRMCPPlus, rtype, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint16(out[14:], uint16(len(resp)))
+	return append(out, resp...)
+}
+
+// ipmiChecksum calculates the two's complement checksum of a message segment
+func ipmiChecksum(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return -sum
+}
+
+// ipmiHandleMessage answers the unauthenticated IPMI commands needed to start a session
+func ipmiHandleMessage(msg []byte) []byte {
+	if len(msg) < 7 {
+		return nil
+	}
+
+	netFn := msg[1] >> 2
+	cmd := msg[5]
+	data := msg[6 : len(msg)-1]
+
+	ccode := byte(0x00)
+	rdata := []byte{}
+
+	switch {
+	case netFn == 0x06 && cmd == ipmiCmdGetChannelAuthCap:
+		// Channel 1, IPMI v2.0 extended data with MD5 and straight password,
+		// non-null usernames, and IPMI v2.0 connections supported
+		rdata = []byte{0x01, 0x80 | 0x04 | 0x10, 0x04, 0x02, 0x00, 0x00, 0x00, 0x00}
+
+	case netFn == 0x06 && cmd == ipmiCmdGetChannelCipherSuites:
+		// Only offer cipher suite 3 (RAKP-HMAC-SHA1, HMAC-SHA1-96, AES-CBC-128)
+		rdata = []byte{0x01}
+		if len(data) >= 3 && data[2]&0x3f == 0 {
+			rdata = append(rdata, 0xc0, 0x03, 0x01, 0x41, 0x81)
+		}
+
+	case netFn == 0x06 && cmd == ipmiCmdCloseSession:
+		// Nothing to do
+
+	default:
+		// Invalid command
+		ccode = 0xc1
+	}
+
+	resp := []byte{msg[3], ((netFn | 1) << 2) | (msg[4] & 0x03)}
+	resp = append(resp, ipmiChecksum(resp))
+
+	body := []byte{msg[0], (msg[4] & 0xfc) | (msg[1] & 0x03), cmd, ccode}
+	body = append(body, rdata...)
+	body = append(body, ipmiChecksum(body))
+
+	return append(resp, body...)
+}
+
+// ipmiHandleOpenSession accepts a RMCP+ Open Session Request
+func ipmiHandleOpenSession(c *ConfIPMI, payload []byte) []byte {
+	if len(payload) < 32 {
+		return nil
+	}
+
+	sess := &ipmiSession{
+		ConsoleSessionID: append([]byte{}, payload[4:8]...),
+		SystemSessionID:  make([]byte, 4),
+		AuthAlg:          payload[16] & 0x3f,
+		Created:          time.Now(),
+	}
+	rand.Read(sess.SystemSessionID)
+
+	// Session IDs must be non-zero
+	sess.SystemSessionID[0] |= 0x01
+
+	c.sessionsLock.Lock()
+	for k, v := range c.sessions {
+		if time.Since(v.Created) > ipmiSessionTimeout {
+			delete(c.sessions, k)
+		}
+	}
+	c.sessions[binary.LittleEndian.Uint32(sess.SystemSessionID)] = sess
+	c.sessionsLock.Unlock()
+
+	resp := []byte{payload[0], 0x00, 0x04, 0x00}
+	resp = append(resp, sess.ConsoleSessionID...)
+	resp = append(resp, sess.SystemSessionID...)
+
+	// Echo back the requested authentication, integrity, and confidentiality algorithms
+	resp = append(resp, payload[8:32]...)
+	return resp
+}
+
+// ipmiHandleRAKP1 answers RAKP Message 1 with a RAKP Message 2
+func ipmiHandleRAKP1(c *ConfIPMI, raddr net.Addr, payload []byte) []byte {
+	if len(payload) < 28 {
+		return nil
+	}
+
+	sid := binary.LittleEndian.Uint32(payload[4:8])
+	c.sessionsLock.Lock()
+	sess, ok := c.sessions[sid]
+	c.sessionsLock.Unlock()
+	if !ok {
+		// Invalid session ID
+		return []byte{payload[0], 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	}
+
+	ulen := int(payload[27])
+	if ulen > 16 || len(payload) < 28+ulen {
+		return nil
+	}
+
+	sess.ConsoleRandom = append([]byte{}, payload[8:24]...)
+	sess.Role = payload[24]
+	sess.Username = append([]byte{}, payload[28:28+ulen]...)
+	sess.SystemRandom = make([]byte, 16)
+	rand.Read(sess.SystemRandom)
+
+	// Only the RAKP Message 1 fields can be recorded here. The RAKP Message 2
+	// exchange code is keyed with the account password, which a passive BMC does
+	// not know, so it is computed with an empty key. Clients that verify it, such
+	// as ipmitool, abort before sending RAKP Message 3 unless the account has a
+	// blank password, and no crackable hash is obtained from them.
+	c.RecordWriter.Record(
+		"access",
+		"ipmi",
+		raddr.String(),
+		map[string]string{
+			"_server":        fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+			"username":       string(sess.Username),
+			"role":           fmt.Sprintf("0x%.2x", sess.Role),
+			"auth_alg":       ipmiAuthAlgNames[sess.AuthAlg],
+			"console_random": hex.EncodeToString(sess.ConsoleRandom),
+		},
+	)
+
+	salt := bytes.Join([][]byte{
+		sess.ConsoleSessionID,
+		sess.SystemSessionID,
+		sess.ConsoleRandom,
+		sess.SystemRandom,
+		c.GUID,
+		{sess.Role, byte(len(sess.Username))},
+		sess.Username,
+	}, nil)
+	mac := hmac.New(sha1.New, []byte{})
+	mac.Write(salt)
+
+	resp := []byte{payload[0], 0x00, 0x00, 0x00}
+	resp = append(resp, sess.ConsoleSessionID...)
+	resp = append(resp, sess.SystemRandom...)
+	resp = append(resp, c.GUID...)
+	if sess.AuthAlg != 0x00 {
+		resp = append(resp, mac.Sum(nil)...)
+	}
+	return resp
+}
+
+// ipmiHandleRAKP3 records the HMAC from RAKP Message 3 and rejects the session. This
+// is only reached by clients that skip the RAKP Message 2 check or use a blank password.
+func ipmiHandleRAKP3(c *ConfIPMI, raddr net.Addr, payload []byte) []byte {
+	if len(payload) < 8 {
+		return nil
+	}
+
+	sid := binary.LittleEndian.Uint32(payload[4:8])
+	c.sessionsLock.Lock()
+	sess, ok := c.sessions[sid]
+	delete(c.sessions, sid)
+	c.sessionsLock.Unlock()
+	if !ok || sess.Username == nil {
+		// Invalid session ID
+		return []byte{payload[0], 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	}
+
+	// Rejected by the client (status code is non-zero) or no auth code present
+	authCode := payload[8:]
+	if payload[1] != 0x00 || len(authCode) == 0 {
+		return nil
+	}
+
+	// RAKP Message 3 HMAC covers Rc | SIDm | ROLEm | ULENGTHm | UNAMEm and is keyed with
+	// the password, which hashcat mode 7300 cracks as HMAC-SHA1 over a hex salt
+	salt := bytes.Join([][]byte{
+		sess.SystemRandom,
+		sess.ConsoleSessionID,
+		{sess.Role, byte(len(sess.Username))},
+		sess.Username,
+	}, nil)
+
+	rec := map[string]string{
+		"_server":  fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+		"username": string(sess.Username),
+		"role":     fmt.Sprintf("0x%.2x", sess.Role),
+		"auth_alg": ipmiAuthAlgNames[sess.AuthAlg],
+		"salt":     hex.EncodeToString(salt),
+		"hmac":     hex.EncodeToString(authCode),
+	}
+
+	// hashcat rejects salts under 32 bytes, which is the case for user names under 10 characters
+	if sess.AuthAlg == 0x01 && len(authCode) == sha1.Size && len(salt) >= ipmiHashcatMinSalt {
+		rec["hashcat"] = hex.EncodeToString(salt) + ":" + hex.EncodeToString(authCode)
+		rec["hashcat_mode"] = "7300"
+	}
+	c.RecordWriter.Record("credential", "ipmi", raddr.String(), rec)
+
+	// Invalid integrity check value
+	resp := []byte{payload[0], 0x0f, 0x00, 0x00}
+	return append(resp, sess.ConsoleSessionID...)
+}
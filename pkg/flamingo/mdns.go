@@ -0,0 +1,171 @@
+package flamingo
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+)
+
+// mdnsGroup is the IPv4 mDNS multicast group
+var mdnsGroup = net.IPv4(224, 0, 0, 251)
+
+// mdnsUnicastResponse is the question class bit requesting a unicast response
+const mdnsUnicastResponse = 0x8000
+
+// mdnsCacheFlush is the answer class bit indicating a unique record
+const mdnsCacheFlush = 0x8000
+
+// ConfMDNS describes the options for a mDNS responder
+type ConfMDNS struct {
+	BindPort     uint16
+	BindHost     string
+	RecordWriter *RecordWriter
+	ResponderOptions
+	shutdown bool
+	listener *net.UDPConn
+	m        sync.Mutex
+}
+
+// IsShutdown checks to see if the service is shutting down
+func (c *ConfMDNS) IsShutdown() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.shutdown
+}
+
+// Shutdown flags the service to shut down
+func (c *ConfMDNS) Shutdown() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.shutdown {
+		return
+	}
+	c.shutdown = true
+	c.listener.Close()
+}
+
+// NewConfMDNS creates a default configuration for the mDNS responder
+func NewConfMDNS() *ConfMDNS {
+	return &ConfMDNS{
+		BindPort: 5353,
+		BindHost: "0.0.0.0",
+	}
+}
+
+// SpawnMDNS starts a mDNS responder
+func SpawnMDNS(c *ConfMDNS) error {
+	ifi, err := c.multicastInterface()
+	if err != nil {
+		return fmt.Errorf("failed to find interface %s (%s)", c.Interface, err)
+	}
+
+	listener, err := net.ListenMulticastUDP("udp4", ifi, &net.UDPAddr{IP: mdnsGroup, Port: int(c.BindPort)})
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s:%d (%s)", mdnsGroup, c.BindPort, err)
+	}
+	c.listener = listener
+
+	go mdnsStart(c)
+	return nil
+}
+
+func mdnsStart(c *ConfMDNS) {
+	log.Debugf("mdns is listening on %s:%d", mdnsGroup, c.BindPort)
+
+	buff := make([]byte, 9000)
+	for {
+		if c.IsShutdown() {
+			log.Debugf("mdns responder on %s:%d is shutting down", mdnsGroup, c.BindPort)
+			break
+		}
+
+		rlen, raddr, rerr := c.listener.ReadFromUDP(buff)
+		if rerr != nil {
+			continue
+		}
+
+		data := buff[0:rlen]
+		mdnsProcess(c, raddr, data)
+	}
+}
+
+func mdnsProcess(c *ConfMDNS, raddr *net.UDPAddr, data []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Debugf("mdns decoder panic with data %s: %q", hex.EncodeToString(data), r)
+		}
+	}()
+
+	req := new(dns.Msg)
+	if err := req.Unpack(data); err != nil {
+		return
+	}
+	if req.Response || len(req.Question) == 0 {
+		return
+	}
+
+	// Queries from a port other than 5353 are legacy unicast queries
+	legacy := raddr.Port != int(c.BindPort)
+	unicast := legacy
+
+	answers := []dns.RR{}
+	var answer net.IP
+	for _, q := range req.Question {
+		if q.Qclass&mdnsUnicastResponse != 0 {
+			unicast = true
+		}
+
+		action := responderAction(&c.ResponderOptions, q.Name, raddr.IP)
+		var ip net.IP
+		if action == "poisoned" {
+			if answer == nil {
+				answer = c.AnswerIP(raddr)
+			}
+			var rr dns.RR
+			if answer != nil {
+				rr = responderAnswerRR(dns.Question{Name: q.Name, Qtype: q.Qtype, Qclass: dns.ClassINET}, answer, 120)
+			}
+			if rr == nil {
+				action = "ignored"
+			} else {
+				if !legacy {
+					rr.Header().Class |= mdnsCacheFlush
+				}
+				answers = append(answers, rr)
+				ip = answer
+			}
+		}
+
+		responderRecord(c.RecordWriter, "mdns", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort), raddr, dnsFormatQuestions([]dns.Question{q}), action, ip)
+	}
+
+	if len(answers) == 0 {
+		return
+	}
+
+	m := new(dns.Msg)
+	m.Response = true
+	m.Authoritative = true
+	m.Answer = answers
+
+	// Legacy unicast responses echo the query ID and question
+	if legacy {
+		m.Id = req.Id
+		m.Question = req.Question
+	}
+
+	resp, err := m.Pack()
+	if err != nil {
+		return
+	}
+
+	dst := raddr
+	if !unicast {
+		dst = &net.UDPAddr{IP: mdnsGroup, Port: int(c.BindPort)}
+	}
+	c.listener.WriteToUDP(resp, dst)
+}
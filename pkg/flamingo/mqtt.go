@@ -0,0 +1,334 @@
+package flamingo
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	mqttPacketConnect = 0x01
+	mqttPacketConnack = 0x02
+
+	mqttFlagUsername = 0x80
+	mqttFlagPassword = 0x40
+	mqttFlagWill     = 0x04
+
+	// mqttMaxPacketSize limits the size of a CONNECT packet read from a client
+	mqttMaxPacketSize = 65535
+)
+
+// mqttProtocolVersions maps protocol levels to their names
+var mqttProtocolVersions = map[byte]string{
+	3: "3.1",
+	4: "3.1.1",
+	5: "5.0",
+}
+
+// ConfMQTT describes the options for a MQTT service
+type ConfMQTT struct {
+	BindPort     uint16
+	BindHost     string
+	RecordWriter *RecordWriter
+	TLS          bool
+	TLSName      string
+	TLSCert      string
+	TLSKey       string
+	shutdown     bool
+	listener     net.Listener
+	m            sync.Mutex
+}
+
+// mqttConnect holds the fields parsed from a CONNECT packet
+type mqttConnect struct {
+	ProtocolName string
+	Level        byte
+	Flags        byte
+	ClientID     string
+	WillTopic    string
+	Username     string
+	Password     string
+}
+
+// IsShutdown checks to see if the service is shutting down
+func (c *ConfMQTT) IsShutdown() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.shutdown
+}
+
+// Shutdown flags the service to shut down
+func (c *ConfMQTT) Shutdown() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.shutdown = true
+	c.listener.Close()
+}
+
+// NewConfMQTT creates a default configuration for the MQTT capture server
+func NewConfMQTT() *ConfMQTT {
+	return &ConfMQTT{
+		BindPort: 1883,
+		BindHost: "[::]",
+	}
+}
+
+// SpawnMQTT starts a logging MQTT server, using TLS if configured
+func SpawnMQTT(c *ConfMQTT) error {
+
+	// Handle TLS listeners
+	if c.TLS {
+		tlsConfig := tls.Config{ServerName: c.TLSName}
+		kp, err := tls.X509KeyPair([]byte(c.TLSCert), []byte(c.TLSKey))
+		if err != nil {
+			return fmt.Errorf("failed to load tls cert for mqtts on %s:%d (%s)", c.BindHost, c.BindPort, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{kp}
+
+		listener, err := tls.Listen("tcp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort), &tlsConfig)
+		if err != nil {
+			return fmt.Errorf("failed to listen with tls on %s:%d (%s)", c.BindHost, c.BindPort, err)
+		}
+		c.listener = listener
+		go mqttStart(c)
+		return nil
+	}
+
+	// Handle normal listeners
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s:%d (%s)", c.BindHost, c.BindPort, err)
+	}
+	c.listener = listener
+	go mqttStart(c)
+	return nil
+}
+
+func (c *ConfMQTT) protoName() string {
+	if c.TLS {
+		return "mqtts"
+	}
+	return "mqtt"
+}
+
+func mqttStart(c *ConfMQTT) {
+	log.Debugf("%s is listening on %s:%d", c.protoName(), c.BindHost, c.BindPort)
+	for {
+		if c.IsShutdown() {
+			log.Debugf("%s server on %s:%d is shutting down", c.protoName(), c.BindHost, c.BindPort)
+			break
+		}
+		conn, err := c.listener.Accept()
+		if err != nil {
+			// Triggers on shutdown, will break on reiteration of the loop
+			continue
+		}
+		go mqttHandleConnection(c, conn)
+	}
+}
+
+// mqttReadVarInt reads a variable byte integer from a stream
+func mqttReadVarInt(reader io.ByteReader) (int, error) {
+	val := 0
+	for i := 0; i < 4; i++ {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		val |= int(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			return val, nil
+		}
+	}
+	return 0, fmt.Errorf("malformed variable byte integer")
+}
+
+// mqttDecodeVarInt decodes a variable byte integer from a buffer, returning the new offset
+func mqttDecodeVarInt(data []byte, idx int) (int, int, error) {
+	val := 0
+	for i := 0; i < 4; i++ {
+		if idx >= len(data) {
+			return 0, idx, io.ErrUnexpectedEOF
+		}
+		b := data[idx]
+		idx++
+		val |= int(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			return val, idx, nil
+		}
+	}
+	return 0, idx, fmt.Errorf("malformed variable byte integer")
+}
+
+// mqttDecodeBytes decodes a length-prefixed field, returning the new offset
+func mqttDecodeBytes(data []byte, idx int) ([]byte, int, error) {
+	if idx+2 > len(data) {
+		return nil, idx, io.ErrUnexpectedEOF
+	}
+	size := int(binary.BigEndian.Uint16(data[idx:]))
+	idx += 2
+	if idx+size > len(data) {
+		return nil, idx, io.ErrUnexpectedEOF
+	}
+	return data[idx : idx+size], idx + size, nil
+}
+
+// mqttSkipProperties skips a MQTT 5.0 property list, returning the new offset
+func mqttSkipProperties(data []byte, idx int) (int, error) {
+	size, idx, err := mqttDecodeVarInt(data, idx)
+	if err != nil {
+		return idx, err
+	}
+	if idx+size > len(data) {
+		return idx, io.ErrUnexpectedEOF
+	}
+	return idx + size, nil
+}
+
+// mqttParseConnect decodes the variable header and payload of a CONNECT packet
+func mqttParseConnect(data []byte) (*mqttConnect, error) {
+	req := &mqttConnect{}
+
+	name, idx, err := mqttDecodeBytes(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	req.ProtocolName = string(name)
+	if req.ProtocolName != "MQTT" && req.ProtocolName != "MQIsdp" {
+		return nil, fmt.Errorf("unsupported protocol name %q", req.ProtocolName)
+	}
+
+	// Protocol level, connect flags, and keep alive
+	if idx+4 > len(data) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	req.Level = data[idx]
+	req.Flags = data[idx+1]
+	idx += 4
+
+	if req.Level >= 5 {
+		if idx, err = mqttSkipProperties(data, idx); err != nil {
+			return nil, err
+		}
+	}
+
+	field, idx, err := mqttDecodeBytes(data, idx)
+	if err != nil {
+		return nil, err
+	}
+	req.ClientID = string(field)
+
+	if req.Flags&mqttFlagWill != 0 {
+		if req.Level >= 5 {
+			if idx, err = mqttSkipProperties(data, idx); err != nil {
+				return nil, err
+			}
+		}
+		if field, idx, err = mqttDecodeBytes(data, idx); err != nil {
+			return nil, err
+		}
+		req.WillTopic = string(field)
+
+		// Skip the will message
+		if _, idx, err = mqttDecodeBytes(data, idx); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.Flags&mqttFlagUsername != 0 {
+		if field, idx, err = mqttDecodeBytes(data, idx); err != nil {
+			return nil, err
+		}
+		req.Username = string(field)
+	}
+
+	if req.Flags&mqttFlagPassword != 0 {
+		if field, _, err = mqttDecodeBytes(data, idx); err != nil {
+			return nil, err
+		}
+		req.Password = string(field)
+	}
+
+	return req, nil
+}
+
+// mqttConnackPacket creates a CONNACK packet refusing the connection
+func mqttConnackPacket(level byte, credentials bool) []byte {
+	if level >= 5 {
+		// Bad User Name or Password, Not authorized
+		reason := byte(0x87)
+		if credentials {
+			reason = 0x86
+		}
+		return []byte{mqttPacketConnack << 4, 0x03, 0x00, reason, 0x00}
+	}
+
+	// Bad user name or password, Not authorized
+	code := byte(0x05)
+	if credentials {
+		code = 0x04
+	}
+	return []byte{mqttPacketConnack << 4, 0x02, 0x00, code}
+}
+
+func mqttHandleConnection(c *ConfMQTT, conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	reader := bufio.NewReader(conn)
+
+	// The first packet must be a CONNECT
+	ptype, err := reader.ReadByte()
+	if err != nil || ptype>>4 != mqttPacketConnect {
+		return
+	}
+
+	size, err := mqttReadVarInt(reader)
+	if err != nil || size > mqttMaxPacketSize {
+		return
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return
+	}
+
+	req, err := mqttParseConnect(data)
+	if err != nil {
+		log.Debugf("%s failed to parse connect from %s: %s", c.protoName(), conn.RemoteAddr().String(), err)
+		return
+	}
+
+	version, ok := mqttProtocolVersions[req.Level]
+	if !ok {
+		version = strconv.Itoa(int(req.Level))
+	}
+
+	rec := map[string]string{
+		"_server":   fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+		"client_id": req.ClientID,
+		"version":   version,
+	}
+	if req.WillTopic != "" {
+		rec["will_topic"] = req.WillTopic
+	}
+
+	credentials := req.Flags&(mqttFlagUsername|mqttFlagPassword) != 0
+	if credentials {
+		rec["username"] = req.Username
+		rec["password"] = req.Password
+		c.RecordWriter.Record("credential", c.protoName(), conn.RemoteAddr().String(), rec)
+	} else {
+		c.RecordWriter.Record("access", c.protoName(), conn.RemoteAddr().String(), rec)
+	}
+
+	conn.Write(mqttConnackPacket(req.Level, credentials))
+}
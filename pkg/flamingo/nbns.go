@@ -0,0 +1,189 @@
+package flamingo
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	nbnsTypeNB    = 0x0020
+	nbnsClassIN   = 0x0001
+	nbnsNameLen   = 34
+	nbnsHeaderLen = 12
+)
+
+var nbnsSuffixNames = map[byte]string{
+	0x00: "workstation",
+	0x03: "messenger",
+	0x1b: "domain master browser",
+	0x1c: "domain controllers",
+	0x1d: "master browser",
+	0x1e: "browser election",
+	0x20: "file server",
+}
+
+// ConfNBNS describes the options for a NetBIOS Name Service responder
+type ConfNBNS struct {
+	BindPort     uint16
+	BindHost     string
+	RecordWriter *RecordWriter
+	ResponderOptions
+	shutdown bool
+	listener net.PacketConn
+	m        sync.Mutex
+}
+
+// IsShutdown checks to see if the service is shutting down
+func (c *ConfNBNS) IsShutdown() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.shutdown
+}
+
+// Shutdown flags the service to shut down
+func (c *ConfNBNS) Shutdown() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.shutdown {
+		return
+	}
+	c.shutdown = true
+	c.listener.Close()
+}
+
+// NewConfNBNS creates a default configuration for the NBT-NS responder
+func NewConfNBNS() *ConfNBNS {
+	return &ConfNBNS{
+		BindPort: 137,
+		BindHost: "0.0.0.0",
+	}
+}
+
+// SpawnNBNS starts a NBT-NS responder
+func SpawnNBNS(c *ConfNBNS) error {
+	// Broadcast queries are only received by IPv4 wildcard listeners
+	listener, err := net.ListenPacket("udp4", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s:%d (%s)", c.BindHost, c.BindPort, err)
+	}
+	c.listener = listener
+
+	go nbnsStart(c)
+	return nil
+}
+
+func nbnsStart(c *ConfNBNS) {
+	log.Debugf("nbns is listening on %s:%d", c.BindHost, c.BindPort)
+
+	buff := make([]byte, 4096)
+	for {
+		if c.IsShutdown() {
+			log.Debugf("nbns responder on %s:%d is shutting down", c.BindHost, c.BindPort)
+			break
+		}
+
+		rlen, raddr, rerr := c.listener.ReadFrom(buff)
+		if rerr != nil {
+			continue
+		}
+
+		data := buff[0:rlen]
+		nbnsProcess(c, raddr, data)
+	}
+}
+
+// nbnsDecodeName decodes a first-level encoded NetBIOS name into the name and suffix
+func nbnsDecodeName(data []byte) (string, byte, error) {
+	if len(data) < nbnsNameLen || data[0] != 0x20 || data[33] != 0x00 {
+		return "", 0, fmt.Errorf("invalid netbios name")
+	}
+
+	raw := make([]byte, 16)
+	for i := 0; i < 16; i++ {
+		hi := data[1+i*2] - 'A'
+		lo := data[2+i*2] - 'A'
+		if hi > 0x0f || lo > 0x0f {
+			return "", 0, fmt.Errorf("invalid netbios name encoding")
+		}
+		raw[i] = hi<<4 | lo
+	}
+
+	return strings.TrimRight(string(raw[0:15]), " "), raw[15], nil
+}
+
+func nbnsProcess(c *ConfNBNS, raddr net.Addr, data []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Debugf("nbns decoder panic with data %s: %q", hex.EncodeToString(data), r)
+		}
+	}()
+
+	if len(data) < nbnsHeaderLen+nbnsNameLen+4 {
+		return
+	}
+
+	// Only handle name query requests
+	flags := binary.BigEndian.Uint16(data[2:4])
+	if flags&0x8000 != 0 || (flags>>11)&0x0f != 0 {
+		return
+	}
+	if binary.BigEndian.Uint16(data[4:6]) != 1 {
+		return
+	}
+
+	qname := data[nbnsHeaderLen : nbnsHeaderLen+nbnsNameLen]
+	qtype := binary.BigEndian.Uint16(data[nbnsHeaderLen+nbnsNameLen:])
+	if qtype != nbnsTypeNB {
+		return
+	}
+
+	name, suffix, err := nbnsDecodeName(qname)
+	if err != nil {
+		return
+	}
+
+	udpAddr, ok := raddr.(*net.UDPAddr)
+	if !ok {
+		return
+	}
+
+	question := fmt.Sprintf("NB/%s<%.2x>", name, suffix)
+	if sname, ok := nbnsSuffixNames[suffix]; ok {
+		question += fmt.Sprintf(" (%s)", sname)
+	}
+
+	action := responderAction(&c.ResponderOptions, name, udpAddr.IP)
+	var answer net.IP
+	if action == "poisoned" {
+		answer = c.AnswerIP(udpAddr).To4()
+		if answer == nil {
+			action = "ignored"
+		}
+	}
+
+	responderRecord(c.RecordWriter, "nbns", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort), raddr, []string{question}, action, answer)
+
+	if answer == nil {
+		return
+	}
+
+	// Positive name query response with a single NB record
+	resp := make([]byte, 0, nbnsHeaderLen+nbnsNameLen+16)
+	resp = append(resp, data[0:2]...)
+	resp = append(resp, 0x85, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00)
+	resp = append(resp, qname...)
+	resp = binary.BigEndian.AppendUint16(resp, nbnsTypeNB)
+	resp = binary.BigEndian.AppendUint16(resp, nbnsClassIN)
+	resp = binary.BigEndian.AppendUint32(resp, 165)
+	resp = binary.BigEndian.AppendUint16(resp, 6)
+	resp = append(resp, 0x00, 0x00)
+	resp = append(resp, answer...)
+
+	c.listener.WriteTo(resp, raddr)
+}
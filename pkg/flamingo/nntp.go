@@ -0,0 +1,241 @@
+package flamingo
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ConfNNTP describes the options for a NNTP service
+type ConfNNTP struct {
+	BindPort     uint16
+	BindHost     string
+	ServerName   string
+	RecordWriter *RecordWriter
+	TLS          bool
+	TLSName      string
+	TLSCert      string
+	TLSKey       string
+	shutdown     bool
+	listener     net.Listener
+	m            sync.Mutex
+}
+
+// IsShutdown checks to see if the service is shutting down
+func (c *ConfNNTP) IsShutdown() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.shutdown
+}
+
+// Shutdown flags the service to shut down
+func (c *ConfNNTP) Shutdown() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.shutdown = true
+	c.listener.Close()
+}
+
+// NewConfNNTP creates a default configuration for the NNTP capture server
+func NewConfNNTP() *ConfNNTP {
+	return &ConfNNTP{
+		BindPort:   119,
+		BindHost:   "[::]",
+		ServerName: "news.local",
+	}
+}
+
+// SpawnNNTP starts a logging NNTP server, using TLS if configured
+func SpawnNNTP(c *ConfNNTP) error {
+
+	// Handle TLS listeners
+	if c.TLS {
+		tlsConfig := tls.Config{ServerName: c.TLSName}
+		kp, err := tls.X509KeyPair([]byte(c.TLSCert), []byte(c.TLSKey))
+		if err != nil {
+			return fmt.Errorf("failed to load tls cert for nntps on %s:%d (%s)", c.BindHost, c.BindPort, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{kp}
+
+		listener, err := tls.Listen("tcp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort), &tlsConfig)
+		if err != nil {
+			return fmt.Errorf("failed to listen with tls on %s:%d (%s)", c.BindHost, c.BindPort, err)
+		}
+		c.listener = listener
+		go nntpStart(c)
+		return nil
+	}
+
+	// Handle normal listeners
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s:%d (%s)", c.BindHost, c.BindPort, err)
+	}
+	c.listener = listener
+	go nntpStart(c)
+	return nil
+}
+
+func (c *ConfNNTP) protoName() string {
+	if c.TLS {
+		return "nntps"
+	}
+	return "nntp"
+}
+
+func nntpStart(c *ConfNNTP) {
+	log.Debugf("%s is listening on %s:%d", c.protoName(), c.BindHost, c.BindPort)
+	for {
+		if c.IsShutdown() {
+			log.Debugf("%s server on %s:%d is shutting down", c.protoName(), c.BindHost, c.BindPort)
+			break
+		}
+		conn, err := c.listener.Accept()
+		if err != nil {
+			// Triggers on shutdown, will break on reiteration of the loop
+			continue
+		}
+		go nntpHandleConnection(c, conn)
+	}
+}
+
+// nntpRecord writes a credential record for the connection
+func nntpRecord(c *ConfNNTP, conn net.Conn, method string, username string, password string) {
+	c.RecordWriter.Record("credential", c.protoName(), conn.RemoteAddr().String(), map[string]string{
+		"_server":  fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+		"method":   method,
+		"username": username,
+		"password": password,
+	})
+}
+
+// nntpDecodePlain extracts the username and password from a SASL PLAIN response
+func nntpDecodePlain(data string) (string, string, bool) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+	if err != nil {
+		return "", "", false
+	}
+	parts := strings.SplitN(string(raw), "\x00", 3)
+	if len(parts) != 3 {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+func nntpHandleConnection(c *ConfNNTP, conn net.Conn) {
+	defer conn.Close()
+
+	reply := func(msg string) error {
+		_, err := conn.Write([]byte(msg + "\r\n"))
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	username := ""
+	saslPending := false
+
+	if err := reply(fmt.Sprintf("200 %s NNTP Service Ready, posting allowed", c.ServerName)); err != nil {
+		return
+	}
+
+	for {
+		conn.SetDeadline(time.Now().Add(60 * time.Second))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		// The response to an empty SASL challenge is sent on its own line
+		if saslPending {
+			saslPending = false
+			if line == "*" {
+				reply("481 Authentication aborted")
+				continue
+			}
+			if user, pass, ok := nntpDecodePlain(line); ok {
+				nntpRecord(c, conn, "sasl", user, pass)
+			}
+			reply("481 Authentication failed")
+			continue
+		}
+
+		bits := strings.Fields(line)
+		if len(bits) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(bits[0]) {
+		case "CAPABILITIES":
+			reply("101 Capability list:\r\nVERSION 2\r\nIMPLEMENTATION INN 2.6.4\r\nREADER\r\nPOST\r\nAUTHINFO USER SASL\r\nSASL PLAIN\r\nLIST ACTIVE NEWSGROUPS OVERVIEW.FMT\r\nOVER\r\n.")
+
+		case "MODE":
+			reply("200 Posting allowed")
+
+		case "DATE":
+			reply("111 " + time.Now().UTC().Format("20060102150405"))
+
+		case "HELP":
+			reply("100 Help text follows\r\n.")
+
+		case "QUIT":
+			reply("205 Bye!")
+			return
+
+		case "AUTHINFO":
+			if len(bits) < 2 {
+				reply("501 Syntax error")
+				continue
+			}
+
+			// Keep everything after the subcommand, since passwords may contain spaces
+			arg := ""
+			if args := strings.SplitN(strings.TrimSpace(line), " ", 3); len(args) == 3 {
+				arg = strings.TrimSpace(args[2])
+			}
+			switch strings.ToUpper(bits[1]) {
+			case "USER":
+				username = arg
+				reply("381 Password required")
+			case "PASS":
+				if username == "" {
+					reply("482 Authentication commands issued out of sequence")
+					continue
+				}
+				nntpRecord(c, conn, "authinfo", username, arg)
+				username = ""
+				reply("481 Authentication failed")
+			case "SASL":
+				if len(bits) < 3 || strings.ToUpper(bits[2]) != "PLAIN" {
+					reply("503 Mechanism not recognized")
+					continue
+				}
+				if len(bits) < 4 {
+					saslPending = true
+					reply("383 =")
+					continue
+				}
+				if user, pass, ok := nntpDecodePlain(bits[3]); ok {
+					nntpRecord(c, conn, "sasl", user, pass)
+				}
+				reply("481 Authentication failed")
+			default:
+				reply("501 Unknown AUTHINFO subcommand")
+			}
+
+		case "GROUP", "LISTGROUP", "ARTICLE", "HEAD", "BODY", "STAT", "NEXT", "LAST",
+			"LIST", "OVER", "XOVER", "XHDR", "HDR", "NEWGROUPS", "NEWNEWS", "POST", "IHAVE":
+			reply("480 Authentication required")
+
+		default:
+			reply("500 Unknown command")
+		}
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"strings"
 )
 
+// ntlmsspSignature prefixes every NTLMSSP message
+var ntlmsspSignature = []byte{'N', 'T', 'L', 'M', 'S', 'S', 'P', 0x00}
+
 func ntlmsspExtractFieldsFromBlob(blob []byte) map[string]string {
 	var err error
 	res := make(map[string]string)
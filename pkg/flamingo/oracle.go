@@ -0,0 +1,484 @@
+package flamingo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	tnsPacketConnect = 1
+	tnsPacketAccept  = 2
+	tnsPacketData    = 6
+	tnsPacketMarker  = 12
+
+	// tnsVersion is the highest version that still uses 16-bit packet lengths
+	tnsVersion = 313
+
+	ttcMsgProtocol  = 0x01
+	ttcMsgDataTypes = 0x02
+	ttcMsgFunction  = 0x03
+	ttcMsgParameter = 0x08
+	ttcMsgStatus    = 0x09
+
+	// ttcVerifier11g is the AUTH_VFR_DATA flag for 11g SHA1 password verifiers
+	ttcVerifier11g = 0x1b25
+)
+
+// tnsConnectDataPattern extracts key=value pairs from a TNS connect descriptor
+var tnsConnectDataPattern = regexp.MustCompile(`(?i)\(([A-Z_]+)=([^()]*)\)`)
+
+// ConfOracle describes the options for an Oracle TNS listener
+type ConfOracle struct {
+	BindPort     uint16
+	BindHost     string
+	RecordWriter *RecordWriter
+	shutdown     bool
+	listener     net.Listener
+	m            sync.Mutex
+}
+
+// oracleSession holds the details collected from a single client connection
+type oracleSession struct {
+	ConnectData   map[string]string
+	Username      string
+	Params        map[string]string
+	ServerSessKey string
+	VerifierData  string
+}
+
+// IsShutdown checks to see if the service is shutting down
+func (c *ConfOracle) IsShutdown() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.shutdown
+}
+
+// Shutdown flags the service to shut down
+func (c *ConfOracle) Shutdown() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.shutdown = true
+	c.listener.Close()
+}
+
+// NewConfOracle creates a default configuration for the Oracle capture server
+func NewConfOracle() *ConfOracle {
+	return &ConfOracle{
+		BindPort: 1521,
+		BindHost: "[::]",
+	}
+}
+
+// SpawnOracle starts a logging Oracle TNS server
+func SpawnOracle(c *ConfOracle) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s:%d (%s)", c.BindHost, c.BindPort, err)
+	}
+	c.listener = listener
+	go oracleStart(c)
+	return nil
+}
+
+func oracleStart(c *ConfOracle) {
+	log.Debugf("oracle is listening on %s:%d", c.BindHost, c.BindPort)
+	for {
+		if c.IsShutdown() {
+			log.Debugf("oracle server on %s:%d is shutting down", c.BindHost, c.BindPort)
+			break
+		}
+		conn, err := c.listener.Accept()
+		if err != nil {
+			// Triggers on shutdown, will break on reiteration of the loop
+			continue
+		}
+		go oracleHandleConnectionWrapped(c, conn)
+	}
+}
+
+func oracleHandleConnectionWrapped(c *ConfOracle, conn net.Conn) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Debugf("oracle connection handler panic: %q", r)
+		}
+	}()
+	oracleHandleConnection(c, conn)
+}
+
+// tnsReadPacket reads a TNS packet and returns the type and the body following the header
+func tnsReadPacket(reader *bufio.Reader) (byte, []byte, error) {
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(reader, hdr); err != nil {
+		return 0, nil, err
+	}
+
+	plen := int(binary.BigEndian.Uint16(hdr[0:2]))
+	if plen < 8 {
+		return 0, nil, fmt.Errorf("invalid tns packet length %d", plen)
+	}
+
+	body := make([]byte, plen-8)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return 0, nil, err
+	}
+	return hdr[4], body, nil
+}
+
+// tnsWritePacket sends a TNS packet with the specified type and body
+func tnsWritePacket(conn net.Conn, ptype byte, body []byte) error {
+	pkt := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint16(pkt[0:2], uint16(8+len(body)))
+	pkt[4] = ptype
+	pkt = append(pkt, body...)
+	_, err := conn.Write(pkt)
+	return err
+}
+
+// tnsWriteData sends a TTC payload inside a TNS data packet
+func tnsWriteData(conn net.Conn, payload []byte) error {
+	return tnsWritePacket(conn, tnsPacketData, append([]byte{0x00, 0x00}, payload...))
+}
+
+func oracleHandleConnection(c *ConfOracle, conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(60 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	sess := &oracleSession{
+		ConnectData: make(map[string]string),
+		Params:      make(map[string]string),
+	}
+
+	// Wait for the CONNECT packet
+	ptype, body, err := tnsReadPacket(reader)
+	if err != nil || ptype != tnsPacketConnect || len(body) < 20 {
+		return
+	}
+
+	// Connect data that does not fit in the packet is sent immediately afterwards
+	cdLen := int(binary.BigEndian.Uint16(body[16:18]))
+	cdOff := int(binary.BigEndian.Uint16(body[18:20])) - 8
+	var connectData []byte
+	if cdOff >= 0 && cdOff+cdLen <= len(body) {
+		connectData = body[cdOff : cdOff+cdLen]
+	} else if cdLen > 0 {
+		connectData = make([]byte, cdLen)
+		if _, err := io.ReadFull(reader, connectData); err != nil {
+			return
+		}
+	}
+	for _, m := range tnsConnectDataPattern.FindAllStringSubmatch(string(connectData), -1) {
+		sess.ConnectData[strings.ToUpper(m[1])] = m[2]
+	}
+
+	if err := tnsWritePacket(conn, tnsPacketAccept, oracleAcceptBody()); err != nil {
+		return
+	}
+
+	for {
+		ptype, body, err := tnsReadPacket(reader)
+		if err != nil {
+			break
+		}
+		if ptype == tnsPacketMarker {
+			continue
+		}
+		if ptype != tnsPacketData {
+			break
+		}
+
+		// Skip the data flags
+		if len(body) < 3 {
+			continue
+		}
+		payload := body[2:]
+
+		// Native network encryption negotiation is not supported
+		if bytes.HasPrefix(payload, []byte{0xde, 0xad, 0xbe, 0xef}) {
+			break
+		}
+
+		var resp []byte
+		switch {
+		case bytes.Contains(payload, []byte("AUTH_PASSWORD")):
+			oracleParseAuthParams(payload, sess.Params)
+			oracleRecord(c, conn, sess, "credential")
+			return
+
+		case bytes.Contains(payload, []byte("AUTH_PROGRAM_NM")) || bytes.Contains(payload, []byte("AUTH_TERMINAL")):
+			sess.Username = oracleExtractUsername(payload)
+			oracleParseAuthParams(payload, sess.Params)
+			sess.ServerSessKey = strings.ToUpper(RandomHex(48))
+			sess.VerifierData = strings.ToUpper(RandomHex(10))
+			resp = oracleSessKeyResponse(sess)
+
+		case payload[0] == ttcMsgProtocol:
+			resp = oracleProtocolResponse()
+
+		case payload[0] == ttcMsgDataTypes:
+			resp = oracleDataTypesResponse(payload)
+		}
+
+		// Unexpected function calls before authentication end the session
+		if resp == nil || tnsWriteData(conn, resp) != nil {
+			break
+		}
+	}
+
+	// The client gave up after the session key was sent
+	if sess.Username != "" {
+		oracleRecord(c, conn, sess, "access")
+	}
+}
+
+// oracleAcceptBody creates the body of an ACCEPT packet for the negotiated version
+func oracleAcceptBody() []byte {
+	body := make([]byte, 32)
+	binary.BigEndian.PutUint16(body[0:2], tnsVersion)
+	binary.BigEndian.PutUint16(body[2:4], 0x0001)
+	binary.BigEndian.PutUint16(body[4:6], 0x2000)
+	binary.BigEndian.PutUint16(body[6:8], 0xffff)
+	binary.BigEndian.PutUint16(body[8:10], 0x0100)
+	binary.BigEndian.PutUint16(body[10:12], 0)
+	binary.BigEndian.PutUint16(body[12:14], uint16(8+len(body)))
+
+	// Native network services are disabled to skip encryption negotiation
+	body[14] = 0x04
+	binary.BigEndian.PutUint32(body[24:28], 0x2000)
+	binary.BigEndian.PutUint32(body[28:32], 0xffff)
+	return body
+}
+
+// oracleProtocolResponse answers the TTC protocol negotiation as an 11.2 server
+func oracleProtocolResponse() []byte {
+	var buff bytes.Buffer
+	buff.WriteByte(ttcMsgProtocol)
+	buff.WriteByte(0x06)
+	buff.WriteByte(0x00)
+	buff.WriteString("x86_64/Linux 2.4.xx")
+	buff.WriteByte(0x00)
+
+	// AL32UTF8 character set, flags, and no elements
+	buff.Write([]byte{0x69, 0x03, 0x01, 0x00, 0x00})
+
+	// Field descriptor with the AL16UTF16 national character set
+	fdo := make([]byte, 16)
+	fdo[9] = 0x07
+	fdo[10] = 0xd0
+	buff.Write([]byte{0x00, byte(len(fdo))})
+	buff.Write(fdo)
+
+	// Compile time capabilities with TTC field version 11.2
+	ccaps := make([]byte, 53)
+	ccaps[0] = 0x06
+	ccaps[1] = 0x01
+	ccaps[7] = 0x06
+	buff.WriteByte(byte(len(ccaps)))
+	buff.Write(ccaps)
+
+	// Runtime capabilities
+	rcaps := []byte{0x02, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00}
+	buff.WriteByte(byte(len(rcaps)))
+	buff.Write(rcaps)
+
+	return buff.Bytes()
+}
+
+// oracleDataTypesResponse accepts every data type representation requested by the client
+func oracleDataTypesResponse(payload []byte) []byte {
+	resp := []byte{ttcMsgDataTypes}
+
+	// Skip the character sets, encoding flags, and capability arrays
+	idx := 6
+	for i := 0; i < 2 && idx < len(payload); i++ {
+		idx += 1 + int(payload[idx])
+	}
+
+	for idx+8 <= len(payload) {
+		dty := binary.BigEndian.Uint16(payload[idx:])
+		if dty == 0 {
+			break
+		}
+		conv := binary.BigEndian.Uint16(payload[idx+2:])
+		resp = binary.BigEndian.AppendUint16(resp, dty)
+		resp = binary.BigEndian.AppendUint16(resp, conv)
+		if conv != 0 {
+			resp = append(resp, payload[idx+4:idx+8]...)
+		}
+		idx += 8
+	}
+
+	return append(resp, 0x00, 0x00)
+}
+
+// ttcAppendUB4 appends a variable-length TTC integer
+func ttcAppendUB4(buff []byte, val uint32) []byte {
+	switch {
+	case val == 0:
+		return append(buff, 0x00)
+	case val <= 0xff:
+		return append(buff, 0x01, byte(val))
+	case val <= 0xffff:
+		return append(buff, 0x02, byte(val>>8), byte(val))
+	}
+	return append(buff, 0x04, byte(val>>24), byte(val>>16), byte(val>>8), byte(val))
+}
+
+// ttcAppendKeyValue appends a TTC key/value pair with the specified flags
+func ttcAppendKeyValue(buff []byte, key string, val string, flags uint32) []byte {
+	buff = ttcAppendUB4(buff, uint32(len(key)))
+	buff = append(buff, byte(len(key)))
+	buff = append(buff, key...)
+	buff = ttcAppendUB4(buff, uint32(len(val)))
+	if len(val) > 0 {
+		buff = append(buff, byte(len(val)))
+		buff = append(buff, val...)
+	}
+	return ttcAppendUB4(buff, flags)
+}
+
+// oracleSessKeyResponse creates the O5LOGON phase one response with the session key and salt
+func oracleSessKeyResponse(sess *oracleSession) []byte {
+	resp := []byte{ttcMsgParameter}
+	resp = append(resp, 0x01, 0x02)
+	resp = ttcAppendKeyValue(resp, "AUTH_SESSKEY", sess.ServerSessKey, 0)
+	resp = ttcAppendKeyValue(resp, "AUTH_VFR_DATA", sess.VerifierData, ttcVerifier11g)
+
+	// Call status and end-to-end sequence number
+	return append(resp, ttcMsgStatus, 0x00, 0x00)
+}
+
+// ttcReadUB4 reads a variable-length TTC integer
+func ttcReadUB4(data []byte, idx int) (uint32, int, bool) {
+	if idx >= len(data) {
+		return 0, idx, false
+	}
+	size := int(data[idx] & 0x7f)
+	idx++
+	if size > 4 || idx+size > len(data) {
+		return 0, idx, false
+	}
+	val := uint32(0)
+	for i := 0; i < size; i++ {
+		val = val<<8 | uint32(data[idx+i])
+	}
+	return val, idx + size, true
+}
+
+// oracleParseAuthParams finds the AUTH_* key/value pairs in a TTC function call
+func oracleParseAuthParams(payload []byte, params map[string]string) {
+	idx := 0
+	for {
+		off := bytes.Index(payload[idx:], []byte("AUTH_"))
+		if off < 0 {
+			return
+		}
+		kstart := idx + off
+		idx = kstart + 1
+
+		// Keys are prefixed with their length
+		if kstart == 0 {
+			continue
+		}
+		klen := int(payload[kstart-1])
+		if klen < 5 || kstart+klen > len(payload) {
+			continue
+		}
+		key := string(payload[kstart : kstart+klen])
+
+		vlen, vidx, ok := ttcReadUB4(payload, kstart+klen)
+		if !ok {
+			continue
+		}
+		if vlen == 0 {
+			params[key] = ""
+			idx = vidx
+			continue
+		}
+		if vidx >= len(payload) || int(payload[vidx]) != int(vlen) || vidx+1+int(vlen) > len(payload) {
+			continue
+		}
+		params[key] = string(payload[vidx+1 : vidx+1+int(vlen)])
+		idx = vidx + 1 + int(vlen)
+	}
+}
+
+// oracleExtractUsername finds the length-prefixed username preceding the first key/value pair
+func oracleExtractUsername(payload []byte) string {
+	kstart := bytes.Index(payload, []byte("AUTH_"))
+	if kstart < 3 {
+		return ""
+	}
+
+	// Skip the key length byte and the variable-length key size before it
+	uend := kstart - 1
+	for p := uend - 1; p >= 0 && p >= uend-5; p-- {
+		if payload[p] <= 4 && int(payload[p]) == uend-p-1 {
+			uend = p
+			break
+		}
+	}
+
+	// Usernames are printable, so the first matching length byte marks the start
+	for p := uend - 1; p >= 0 && p >= uend-256; p-- {
+		if payload[p] > 0 && int(payload[p]) == uend-p-1 {
+			return string(payload[p+1 : uend])
+		}
+	}
+	return ""
+}
+
+// oracleRecord writes the collected session details
+func oracleRecord(c *ConfOracle, conn net.Conn, sess *oracleSession, rtype string) {
+	rec := map[string]string{
+		"_server":       fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+		"username":      sess.Username,
+		"service":       sess.ConnectData["SERVICE_NAME"],
+		"sid":           sess.ConnectData["SID"],
+		"program":       sess.Params["AUTH_PROGRAM_NM"],
+		"machine":       sess.Params["AUTH_MACHINE"],
+		"terminal":      sess.Params["AUTH_TERMINAL"],
+		"os_user":       sess.Params["AUTH_SID"],
+		"auth_sesskey":  sess.ServerSessKey,
+		"auth_vfr_data": sess.VerifierData,
+		"method":        "o5logon",
+	}
+
+	// Fall back to the connect descriptor when the auth parameters are missing
+	if rec["program"] == "" {
+		rec["program"] = sess.ConnectData["PROGRAM"]
+	}
+	if rec["machine"] == "" {
+		rec["machine"] = sess.ConnectData["HOST"]
+	}
+	if rec["os_user"] == "" {
+		rec["os_user"] = sess.ConnectData["USER"]
+	}
+
+	for _, k := range []string{"service", "sid"} {
+		if rec[k] == "" {
+			delete(rec, k)
+		}
+	}
+
+	// The server session key is random rather than encrypted with the real password, so
+	// crackers must verify candidates against the client session key and encrypted password
+	if rtype == "credential" {
+		rec["client_sesskey"] = sess.Params["AUTH_SESSKEY"]
+		rec["auth_password"] = sess.Params["AUTH_PASSWORD"]
+		rec["john"] = fmt.Sprintf("$o5logon$%s*%s*%s*%s",
+			sess.ServerSessKey, sess.VerifierData, sess.Params["AUTH_PASSWORD"], sess.Params["AUTH_SESSKEY"])
+	}
+
+	c.RecordWriter.Record(rtype, "oracle", conn.RemoteAddr().String(), rec)
+}
@@ -0,0 +1,146 @@
+package flamingo
+
+import (
+	"net"
+	"path"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ResponderOptions holds the settings shared by the LLMNR, NBT-NS, and mDNS responders
+type ResponderOptions struct {
+	// ResolveToIP is the address returned in answers; if empty, the local address facing the client is used
+	ResolveToIP string
+	// AnalyzeOnly records queries without sending answers
+	AnalyzeOnly bool
+	// Interface optionally names the interface used to join multicast groups
+	Interface string
+	// AllowNames and DenyNames are case-insensitive names or glob patterns
+	AllowNames []string
+	DenyNames  []string
+	// AllowSources and DenySources are addresses or CIDR ranges
+	AllowSources []string
+	DenySources  []string
+}
+
+// responderNameMatch determines if a name matches any of the specified patterns
+func responderNameMatch(name string, patterns []string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, pat := range patterns {
+		pat = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(pat), "."))
+		if pat == "" {
+			continue
+		}
+		if ok, _ := path.Match(pat, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// responderSourceMatch determines if an address matches any of the specified addresses or ranges
+func responderSourceMatch(ip net.IP, sources []string) bool {
+	for _, src := range sources {
+		src = strings.TrimSpace(src)
+		if src == "" {
+			continue
+		}
+		if _, cidr, err := net.ParseCIDR(src); err == nil {
+			if cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if sip := net.ParseIP(src); sip != nil && sip.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Permitted determines whether a query for name from the source address should be answered
+func (o *ResponderOptions) Permitted(name string, src net.IP) bool {
+	if len(o.AllowNames) > 0 && !responderNameMatch(name, o.AllowNames) {
+		return false
+	}
+	if responderNameMatch(name, o.DenyNames) {
+		return false
+	}
+	if len(o.AllowSources) > 0 && !responderSourceMatch(src, o.AllowSources) {
+		return false
+	}
+	if responderSourceMatch(src, o.DenySources) {
+		return false
+	}
+	return true
+}
+
+// AnswerIP returns the address to place in answers sent to the specified client
+func (o *ResponderOptions) AnswerIP(raddr *net.UDPAddr) net.IP {
+	if o.ResolveToIP != "" {
+		return net.ParseIP(o.ResolveToIP)
+	}
+
+	// Find the local address on the route back to the client; no packets are sent
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP
+}
+
+// multicastInterface resolves the configured interface name, if any
+func (o *ResponderOptions) multicastInterface() (*net.Interface, error) {
+	if o.Interface == "" {
+		return nil, nil
+	}
+	return net.InterfaceByName(o.Interface)
+}
+
+// responderRecord records a name query using the same format as the DNS access records
+func responderRecord(rw *RecordWriter, proto string, server string, raddr net.Addr, questions []string, action string, answer net.IP) {
+	rec := map[string]string{
+		"_server":   server,
+		"questions": strings.Join(questions, " "),
+		"action":    action,
+	}
+	if answer != nil {
+		rec["answer"] = answer.String()
+	}
+	rw.Record("access", proto, raddr.String(), rec)
+}
+
+// responderAnswerRR creates an answer record for the question, if the address family matches
+func responderAnswerRR(q dns.Question, ip net.IP, ttl uint32) dns.RR {
+	hdr := dns.RR_Header{Name: q.Name, Class: dns.ClassINET, Ttl: ttl}
+	qtype := q.Qtype
+	if qtype == dns.TypeANY {
+		qtype = dns.TypeA
+		if ip.To4() == nil {
+			qtype = dns.TypeAAAA
+		}
+	}
+
+	switch {
+	case qtype == dns.TypeA && ip.To4() != nil:
+		hdr.Rrtype = dns.TypeA
+		return &dns.A{Hdr: hdr, A: ip.To4()}
+	case qtype == dns.TypeAAAA && ip.To4() == nil:
+		hdr.Rrtype = dns.TypeAAAA
+		return &dns.AAAA{Hdr: hdr, AAAA: ip}
+	}
+	return nil
+}
+
+// responderAction describes the response decision for a query
+func responderAction(o *ResponderOptions, name string, src net.IP) string {
+	switch {
+	case o.AnalyzeOnly:
+		return "analyze"
+	case !o.Permitted(name, src):
+		return "filtered"
+	}
+	return "poisoned"
+}
@@ -0,0 +1,201 @@
+package flamingo
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rsyncProtocolVersion is the protocol version advertised in the greeting
+const rsyncProtocolVersion = "31.0"
+
+// rsyncDigests lists the supported challenge digests in order of preference,
+// matching rsync 3.2 and later; older clients always use md5 or md4
+var rsyncDigests = []string{"sha512", "sha256", "sha1", "md5", "md4"}
+
+// rsyncHashcatModes maps challenge digests to the hashcat mode for $pass.$salt hashes.
+// hashcat has no md4($pass.$salt) mode, so md4 responses get no hashcat field.
+var rsyncHashcatModes = map[string]string{
+	"md5":    "10",
+	"sha1":   "110",
+	"sha256": "1410",
+	"sha512": "1710",
+}
+
+// ConfRSYNC describes the options for a rsync daemon service
+type ConfRSYNC struct {
+	BindPort     uint16
+	BindHost     string
+	Modules      []string
+	RecordWriter *RecordWriter
+	shutdown     bool
+	listener     net.Listener
+	m            sync.Mutex
+}
+
+// IsShutdown checks to see if the service is shutting down
+func (c *ConfRSYNC) IsShutdown() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.shutdown
+}
+
+// Shutdown flags the service to shut down
+func (c *ConfRSYNC) Shutdown() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.shutdown = true
+	c.listener.Close()
+}
+
+// NewConfRSYNC creates a default configuration for the rsync capture server
+func NewConfRSYNC() *ConfRSYNC {
+	return &ConfRSYNC{
+		BindPort: 873,
+		BindHost: "[::]",
+		Modules:  []string{"backup", "data"},
+	}
+}
+
+// SpawnRSYNC starts a logging rsync daemon
+func SpawnRSYNC(c *ConfRSYNC) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s:%d (%s)", c.BindHost, c.BindPort, err)
+	}
+	c.listener = listener
+	go rsyncStart(c)
+	return nil
+}
+
+func rsyncStart(c *ConfRSYNC) {
+	log.Debugf("rsync is listening on %s:%d", c.BindHost, c.BindPort)
+	for {
+		if c.IsShutdown() {
+			log.Debugf("rsync server on %s:%d is shutting down", c.BindHost, c.BindPort)
+			break
+		}
+		conn, err := c.listener.Accept()
+		if err != nil {
+			// Triggers on shutdown, will break on reiteration of the loop
+			continue
+		}
+		go rsyncHandleConnection(c, conn)
+	}
+}
+
+// rsyncNegotiateDigest picks the challenge digest from the client greeting
+func rsyncNegotiateDigest(greeting string) (string, int) {
+	fields := strings.Fields(strings.TrimPrefix(greeting, "@RSYNCD:"))
+	if len(fields) == 0 {
+		return "md4", 0
+	}
+
+	version, _ := strconv.Atoi(strings.SplitN(fields[0], ".", 2)[0])
+
+	// Clients that send a digest list use the first match from the server list
+	if len(fields) > 1 {
+		for _, digest := range rsyncDigests {
+			for _, offered := range fields[1:] {
+				if offered == digest {
+					return digest, version
+				}
+			}
+		}
+	}
+
+	if version >= 30 {
+		return "md5", version
+	}
+	return "md4", version
+}
+
+func rsyncHandleConnection(c *ConfRSYNC, conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	reply := func(msg string) error {
+		_, err := conn.Write([]byte(msg + "\n"))
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	readLine := func() (string, error) {
+		line, err := reader.ReadString('\n')
+		return strings.TrimRight(line, "\r\n"), err
+	}
+
+	if err := reply("@RSYNCD: " + rsyncProtocolVersion + " " + strings.Join(rsyncDigests, " ")); err != nil {
+		return
+	}
+
+	greeting, err := readLine()
+	if err != nil || !strings.HasPrefix(greeting, "@RSYNCD:") {
+		return
+	}
+	digest, version := rsyncNegotiateDigest(greeting)
+
+	module, err := readLine()
+	if err != nil {
+		return
+	}
+
+	// An empty request or #list asks for the module listing
+	if module == "" || module == "#list" {
+		for _, name := range c.Modules {
+			reply(fmt.Sprintf("%-15s\t", name))
+		}
+		reply("@RSYNCD: EXIT")
+		return
+	}
+
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return
+	}
+	challenge := base64.RawStdEncoding.EncodeToString(raw)
+	if err := reply("@RSYNCD: AUTHREQD " + challenge); err != nil {
+		return
+	}
+
+	resp, err := readLine()
+	if err != nil {
+		return
+	}
+
+	// The response is "user hash", where hash is the unpadded base64 of digest(password + challenge)
+	bits := strings.SplitN(resp, " ", 2)
+	if len(bits) != 2 {
+		reply("@ERROR: auth failed on module " + module)
+		return
+	}
+
+	rec := map[string]string{
+		"_server":   fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+		"username":  bits[0],
+		"module":    module,
+		"challenge": challenge,
+		"response":  bits[1],
+		"digest":    digest,
+		"version":   strconv.Itoa(version),
+	}
+
+	if mode, ok := rsyncHashcatModes[digest]; ok {
+		if sum, err := base64.RawStdEncoding.DecodeString(bits[1]); err == nil {
+			rec["hashcat"] = hex.EncodeToString(sum) + ":" + challenge
+			rec["hashcat_mode"] = mode
+		}
+	}
+
+	c.RecordWriter.Record("credential", "rsync", conn.RemoteAddr().String(), rec)
+	reply("@ERROR: auth failed on module " + module)
+}
@@ -0,0 +1,243 @@
+package flamingo
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rtspMaxBodySize limits the size of a request body read from a client
+const rtspMaxBodySize = 65535
+
+// ConfRTSP describes the options for a RTSP service
+type ConfRTSP struct {
+	BindPort     uint16
+	BindHost     string
+	Realm        string
+	AuthMode     string
+	RecordWriter *RecordWriter
+	shutdown     bool
+	listener     net.Listener
+	m            sync.Mutex
+}
+
+// rtspRequest holds a parsed RTSP request
+type rtspRequest struct {
+	Method  string
+	URI     string
+	Headers textproto.MIMEHeader
+}
+
+// IsShutdown checks to see if the service is shutting down
+func (c *ConfRTSP) IsShutdown() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.shutdown
+}
+
+// Shutdown flags the service to shut down
+func (c *ConfRTSP) Shutdown() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.shutdown = true
+	c.listener.Close()
+}
+
+// NewConfRTSP creates a default configuration for the RTSP capture server
+func NewConfRTSP() *ConfRTSP {
+	return &ConfRTSP{
+		BindPort: 554,
+		BindHost: "[::]",
+		Realm:    "IP Camera",
+		AuthMode: "basic",
+	}
+}
+
+// SpawnRTSP starts a logging RTSP server
+func SpawnRTSP(c *ConfRTSP) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s:%d (%s)", c.BindHost, c.BindPort, err)
+	}
+	c.listener = listener
+	go rtspStart(c)
+	return nil
+}
+
+func rtspStart(c *ConfRTSP) {
+	log.Debugf("rtsp is listening on %s:%d", c.BindHost, c.BindPort)
+	for {
+		if c.IsShutdown() {
+			log.Debugf("rtsp server on %s:%d is shutting down", c.BindHost, c.BindPort)
+			break
+		}
+		conn, err := c.listener.Accept()
+		if err != nil {
+			// Triggers on shutdown, will break on reiteration of the loop
+			continue
+		}
+		go rtspHandleConnection(c, conn)
+	}
+}
+
+func rtspHandleConnection(c *ConfRTSP, conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+		req, err := rtspReadRequest(reader)
+		if err != nil {
+			return
+		}
+
+		resp := rtspProcessRequest(c, req, conn.RemoteAddr().String())
+		if _, err := conn.Write(resp); err != nil {
+			return
+		}
+	}
+}
+
+// rtspReadRequest reads a request line and headers, discarding any body
+func rtspReadRequest(reader *bufio.Reader) (*rtspRequest, error) {
+	tp := textproto.NewReader(reader)
+
+	// Skip blank lines between requests
+	line := ""
+	for line == "" {
+		raw, err := tp.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(raw)
+	}
+
+	bits := strings.SplitN(line, " ", 3)
+	if len(bits) != 3 || !strings.HasPrefix(bits[2], "RTSP/") {
+		return nil, fmt.Errorf("invalid request line")
+	}
+
+	headers, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	clen, _ := strconv.Atoi(headers.Get("Content-Length"))
+	if clen > rtspMaxBodySize {
+		return nil, fmt.Errorf("request body too large")
+	}
+	if clen > 0 {
+		if _, err := io.CopyN(io.Discard, reader, int64(clen)); err != nil {
+			return nil, err
+		}
+	}
+
+	return &rtspRequest{
+		Method:  strings.ToUpper(bits[0]),
+		URI:     bits[1],
+		Headers: headers,
+	}, nil
+}
+
+func rtspProcessRequest(c *ConfRTSP, req *rtspRequest, raddr string) []byte {
+	if req.Method == "TEARDOWN" {
+		return rtspBuildResponse(req, 200, "OK", nil)
+	}
+
+	meta := map[string]string{
+		"_server": fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+		"request": req.Method,
+		"url":     req.URI,
+		"agent":   req.Headers.Get("User-Agent"),
+	}
+
+	if !rtspRecordCredentials(c, req, raddr, meta) {
+		c.RecordWriter.Record("access", "rtsp", raddr, meta)
+	}
+
+	// Every request is challenged, including retries with captured credentials
+	challenges := [][2]string{}
+	if c.AuthMode == "digest" || c.AuthMode == "both" {
+		challenges = append(challenges, [2]string{"WWW-Authenticate", fmt.Sprintf("Digest realm=%q, nonce=%q", c.Realm, RandomHex(16))})
+	}
+	if c.AuthMode == "basic" || c.AuthMode == "both" {
+		challenges = append(challenges, [2]string{"WWW-Authenticate", fmt.Sprintf("Basic realm=%q", c.Realm)})
+	}
+	return rtspBuildResponse(req, 401, "Unauthorized", challenges)
+}
+
+// rtspRecordCredentials records Basic credentials or Digest responses, if present
+func rtspRecordCredentials(c *ConfRTSP, req *rtspRequest, raddr string, meta map[string]string) bool {
+	bits := strings.SplitN(strings.TrimSpace(req.Headers.Get("Authorization")), " ", 2)
+	if len(bits) != 2 {
+		return false
+	}
+
+	switch strings.ToLower(bits[0]) {
+	case "basic":
+		rawAuth, err := base64.StdEncoding.DecodeString(strings.TrimSpace(bits[1]))
+		if err != nil {
+			return false
+		}
+		creds := strings.SplitN(string(rawAuth), ":", 2)
+		if len(creds) != 2 {
+			return false
+		}
+		meta["method"] = "basic"
+		meta["username"] = creds[0]
+		meta["password"] = creds[1]
+
+	case "digest":
+		digest := ParseAuthParams(bits[1])
+		if digest["username"] == "" || digest["response"] == "" {
+			return false
+		}
+
+		serverHost := ""
+		if u, err := url.Parse(digest["uri"]); err == nil {
+			serverHost = u.Hostname()
+		}
+		if serverHost == "" {
+			serverHost = strings.Trim(c.BindHost, "[]")
+		}
+
+		meta["method"] = "digest"
+		meta["username"] = digest["username"]
+		meta["realm"] = digest["realm"]
+		meta["hashcat"] = DigestToHashcat(serverHost, raddr, req.Method, digest)
+
+	default:
+		return false
+	}
+
+	c.RecordWriter.Record("credential", "rtsp", raddr, meta)
+	return true
+}
+
+// rtspBuildResponse creates a response echoing the request sequence number
+func rtspBuildResponse(req *rtspRequest, code int, reason string, extra [][2]string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "RTSP/1.0 %d %s\r\n", code, reason)
+	fmt.Fprintf(&b, "CSeq: %s\r\n", req.Headers.Get("CSeq"))
+	if session := req.Headers.Get("Session"); session != "" {
+		fmt.Fprintf(&b, "Session: %s\r\n", session)
+	}
+	for _, hdr := range extra {
+		fmt.Fprintf(&b, "%s: %s\r\n", hdr[0], hdr[1])
+	}
+	b.WriteString("Server: Rtsp Server/3.0\r\n")
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().UTC().Format("Mon, Jan 02 2006 15:04:05 GMT"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
@@ -0,0 +1,398 @@
+package flamingo
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sipCompactHeaders maps compact header forms to their full names
+var sipCompactHeaders = map[string]string{
+	"i": "call-id",
+	"m": "contact",
+	"e": "content-encoding",
+	"l": "content-length",
+	"c": "content-type",
+	"f": "from",
+	"s": "subject",
+	"k": "supported",
+	"t": "to",
+	"v": "via",
+}
+
+// sipMaxBodySize limits the size of a message body read from a stream
+const sipMaxBodySize = 65535
+
+// ConfSIP describes the options for a SIP service
+type ConfSIP struct {
+	BindPort     uint16
+	BindHost     string
+	Realm        string
+	RecordWriter *RecordWriter
+	TLS          bool
+	TLSName      string
+	TLSCert      string
+	TLSKey       string
+	shutdown     bool
+	listener     net.Listener
+	packetConn   net.PacketConn
+	m            sync.Mutex
+}
+
+// sipMessage holds a parsed SIP request
+type sipMessage struct {
+	Method  string
+	URI     string
+	Headers map[string][]string
+	Body    []byte
+}
+
+// Header returns the first value of the named header
+func (s *sipMessage) Header(name string) string {
+	vals := s.Headers[strings.ToLower(name)]
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[0]
+}
+
+// IsShutdown checks to see if the service is shutting down
+func (c *ConfSIP) IsShutdown() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.shutdown
+}
+
+// Shutdown flags the service to shut down
+func (c *ConfSIP) Shutdown() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.shutdown {
+		return
+	}
+	c.shutdown = true
+	if c.listener != nil {
+		c.listener.Close()
+	}
+	if c.packetConn != nil {
+		c.packetConn.Close()
+	}
+}
+
+// NewConfSIP creates a default configuration for the SIP capture server
+func NewConfSIP() *ConfSIP {
+	return &ConfSIP{
+		BindPort: 5060,
+		BindHost: "[::]",
+		Realm:    "asterisk",
+	}
+}
+
+// SpawnSIP starts a logging SIP server over UDP and TCP, or TLS if configured
+func SpawnSIP(c *ConfSIP) error {
+
+	// Handle TLS listeners
+	if c.TLS {
+		tlsConfig := tls.Config{ServerName: c.TLSName}
+		kp, err := tls.X509KeyPair([]byte(c.TLSCert), []byte(c.TLSKey))
+		if err != nil {
+			return fmt.Errorf("failed to load tls cert for sips on %s:%d (%s)", c.BindHost, c.BindPort, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{kp}
+
+		listener, err := tls.Listen("tcp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort), &tlsConfig)
+		if err != nil {
+			return fmt.Errorf("failed to listen with tls on %s:%d (%s)", c.BindHost, c.BindPort, err)
+		}
+		c.listener = listener
+		go sipStartStream(c)
+		return nil
+	}
+
+	// Handle normal listeners, which require both UDP and TCP
+	packetConn, err := net.ListenPacket("udp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on udp %s:%d (%s)", c.BindHost, c.BindPort, err)
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort))
+	if err != nil {
+		packetConn.Close()
+		return fmt.Errorf("failed to listen on tcp %s:%d (%s)", c.BindHost, c.BindPort, err)
+	}
+
+	c.packetConn = packetConn
+	c.listener = listener
+	go sipStartPacket(c)
+	go sipStartStream(c)
+	return nil
+}
+
+func (c *ConfSIP) protoName() string {
+	if c.TLS {
+		return "sips"
+	}
+	return "sip"
+}
+
+func sipStartStream(c *ConfSIP) {
+	log.Debugf("%s is listening on tcp %s:%d", c.protoName(), c.BindHost, c.BindPort)
+	for {
+		if c.IsShutdown() {
+			log.Debugf("%s server on %s:%d is shutting down", c.protoName(), c.BindHost, c.BindPort)
+			break
+		}
+		conn, err := c.listener.Accept()
+		if err != nil {
+			// Triggers on shutdown, will break on reiteration of the loop
+			continue
+		}
+		go sipHandleStream(c, conn)
+	}
+}
+
+func sipStartPacket(c *ConfSIP) {
+	log.Debugf("sip is listening on udp %s:%d", c.BindHost, c.BindPort)
+
+	buff := make([]byte, 65535)
+	for {
+		if c.IsShutdown() {
+			log.Debugf("sip server on udp %s:%d is shutting down", c.BindHost, c.BindPort)
+			break
+		}
+
+		rlen, raddr, rerr := c.packetConn.ReadFrom(buff)
+		if rerr != nil {
+			continue
+		}
+
+		data := make([]byte, rlen)
+		copy(data, buff[0:rlen])
+
+		req, err := sipReadMessage(bufio.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			continue
+		}
+
+		resp := sipProcessRequest(c, req, "udp", raddr.String())
+		if resp != nil {
+			c.packetConn.WriteTo(resp, raddr)
+		}
+	}
+}
+
+func sipHandleStream(c *ConfSIP, conn net.Conn) {
+	defer conn.Close()
+
+	transport := "tcp"
+	if c.TLS {
+		transport = "tls"
+	}
+
+	reader := bufio.NewReader(conn)
+	for {
+		conn.SetDeadline(time.Now().Add(60 * time.Second))
+		req, err := sipReadMessage(reader)
+		if err != nil {
+			return
+		}
+
+		resp := sipProcessRequest(c, req, transport, conn.RemoteAddr().String())
+		if resp != nil {
+			if _, err := conn.Write(resp); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// sipReadMessage reads a single SIP request from the reader
+func sipReadMessage(reader *bufio.Reader) (*sipMessage, error) {
+	req := &sipMessage{Headers: make(map[string][]string)}
+
+	// Skip keep-alive CRLFs before the request line
+	line := ""
+	for line == "" {
+		raw, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(raw, "\r\n")
+	}
+
+	bits := strings.SplitN(line, " ", 3)
+	if len(bits) != 3 || !strings.HasPrefix(bits[2], "SIP/") {
+		return nil, fmt.Errorf("invalid request line")
+	}
+	req.Method = strings.ToUpper(bits[0])
+	req.URI = bits[1]
+
+	lastHeader := ""
+	for {
+		raw, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(raw, "\r\n")
+		if line == "" {
+			break
+		}
+
+		// Handle folded header lines
+		if (line[0] == ' ' || line[0] == '\t') && lastHeader != "" {
+			vals := req.Headers[lastHeader]
+			vals[len(vals)-1] += " " + strings.TrimSpace(line)
+			continue
+		}
+
+		hbits := strings.SplitN(line, ":", 2)
+		if len(hbits) != 2 {
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimSpace(hbits[0]))
+		if full, ok := sipCompactHeaders[name]; ok {
+			name = full
+		}
+		req.Headers[name] = append(req.Headers[name], strings.TrimSpace(hbits[1]))
+		lastHeader = name
+	}
+
+	clen, _ := strconv.Atoi(req.Header("content-length"))
+	if clen > 0 {
+		if clen > sipMaxBodySize {
+			return nil, fmt.Errorf("message body too large")
+		}
+		req.Body = make([]byte, clen)
+		if _, err := io.ReadFull(reader, req.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	return req, nil
+}
+
+// sipProcessRequest records the request and returns the response, if any
+func sipProcessRequest(c *ConfSIP, req *sipMessage, transport string, raddr string) []byte {
+	switch req.Method {
+	case "ACK":
+		// ACKs never receive a response
+		return nil
+
+	case "CANCEL", "BYE":
+		return sipBuildResponse(req, 200, "OK", nil)
+
+	case "OPTIONS":
+		return sipBuildResponse(req, 200, "OK", [][2]string{
+			{"Allow", "INVITE, ACK, CANCEL, OPTIONS, BYE, REGISTER"},
+			{"Accept", "application/sdp"},
+		})
+
+	case "REGISTER", "INVITE":
+		// Handled below
+
+	default:
+		return sipBuildResponse(req, 501, "Not Implemented", nil)
+	}
+
+	auth := req.Header("authorization")
+	if auth == "" {
+		auth = req.Header("proxy-authorization")
+	}
+
+	meta := map[string]string{
+		"_server":   fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+		"transport": transport,
+		"method":    req.Method,
+		"uri":       req.URI,
+		"agent":     req.Header("user-agent"),
+		"contact":   req.Header("contact"),
+		"from":      req.Header("from"),
+	}
+
+	bits := strings.SplitN(auth, " ", 2)
+	if len(bits) != 2 || strings.ToLower(bits[0]) != "digest" {
+		c.RecordWriter.Record("access", c.protoName(), raddr, meta)
+
+		challenge := fmt.Sprintf("Digest realm=%q, nonce=%q, algorithm=MD5", c.Realm, RandomHex(16))
+		return sipBuildResponse(req, 401, "Unauthorized", [][2]string{
+			{"WWW-Authenticate", challenge},
+		})
+	}
+
+	digest := ParseAuthParams(bits[1])
+	if digest["username"] == "" || digest["response"] == "" {
+		return sipBuildResponse(req, 400, "Bad Request", nil)
+	}
+
+	meta["username"] = digest["username"]
+	meta["realm"] = digest["realm"]
+	meta["hashcat"] = sipDigestToHashcat(c, req.Method, raddr, digest)
+	c.RecordWriter.Record("credential", c.protoName(), raddr, meta)
+
+	return sipBuildResponse(req, 403, "Forbidden", nil)
+}
+
+// sipDigestToHashcat converts a SIP digest response to hashcat mode 11400 format
+func sipDigestToHashcat(c *ConfSIP, method string, raddr string, digest map[string]string) string {
+	serverHost := sipURIHost(digest["uri"])
+	if serverHost == "" {
+		serverHost = strings.Trim(c.BindHost, "[]")
+	}
+	return DigestToHashcat(serverHost, raddr, method, digest)
+}
+
+// sipURIHost extracts the host portion of a SIP URI
+func sipURIHost(uri string) string {
+	bits := strings.SplitN(uri, ":", 2)
+	if len(bits) != 2 {
+		return ""
+	}
+	host := bits[1]
+	if idx := strings.LastIndex(host, "@"); idx >= 0 {
+		host = host[idx+1:]
+	}
+	if idx := strings.IndexAny(host, ";?"); idx >= 0 {
+		host = host[:idx]
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.Trim(host, "[]")
+}
+
+// sipBuildResponse creates a response for the request with the specified extra headers
+func sipBuildResponse(req *sipMessage, code int, reason string, extra [][2]string) []byte {
+	var buff bytes.Buffer
+
+	fmt.Fprintf(&buff, "SIP/2.0 %d %s\r\n", code, reason)
+	for _, via := range req.Headers["via"] {
+		fmt.Fprintf(&buff, "Via: %s\r\n", via)
+	}
+
+	to := req.Header("to")
+	if to != "" && !strings.Contains(strings.ToLower(to), ";tag=") {
+		to += ";tag=" + RandomHex(4)
+	}
+
+	fmt.Fprintf(&buff, "From: %s\r\n", req.Header("from"))
+	fmt.Fprintf(&buff, "To: %s\r\n", to)
+	fmt.Fprintf(&buff, "Call-ID: %s\r\n", req.Header("call-id"))
+	fmt.Fprintf(&buff, "CSeq: %s\r\n", req.Header("cseq"))
+	fmt.Fprintf(&buff, "Server: Asterisk PBX 16.2.1\r\n")
+	for _, hdr := range extra {
+		fmt.Fprintf(&buff, "%s: %s\r\n", hdr[0], hdr[1])
+	}
+	fmt.Fprintf(&buff, "Content-Length: 0\r\n\r\n")
+
+	return buff.Bytes()
+}
@@ -2,9 +2,11 @@ package flamingo
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/gosnmp/gosnmp"
 	log "github.com/sirupsen/logrus"
@@ -14,9 +16,14 @@ import (
 type ConfSNMP struct {
 	BindPort     uint16
 	BindHost     string
+	EngineID     string
+	EngineBoots  uint32
+	EngineTime   uint32
 	RecordWriter *RecordWriter
 	shutdown     bool
 	listener     net.PacketConn
+	started      time.Time
+	packets      uint32
 	m            sync.Mutex
 }
 
@@ -41,19 +48,57 @@ func (c *ConfSNMP) Shutdown() {
 // NewConfSNMP creates a default configuration for the SNMP capture server
 func NewConfSNMP() *ConfSNMP {
 	return &ConfSNMP{
-		BindPort: 161,
-		BindHost: "[::]",
+		BindPort:    161,
+		BindHost:    "[::]",
+		EngineID:    "80001f8880" + RandomHex(8),
+		EngineBoots: 1,
 	}
 }
 
 var snmpDecoders = []*gosnmp.GoSNMP{
 	&gosnmp.GoSNMP{Version: gosnmp.Version2c},
-	// TODO: Do something with SNMP v3 requests
-	// &gosnmp.GoSNMP{Version: gosnmp.Version3},
+}
+
+const (
+	// snmpOIDUnknownEngineIDs is the usmStatsUnknownEngineIDs counter returned for discovery requests
+	snmpOIDUnknownEngineIDs = ".1.3.6.1.6.3.15.1.1.4.0"
+
+	// snmpOIDUnknownUserNames is the usmStatsUnknownUserNames counter returned for unauthenticated requests
+	snmpOIDUnknownUserNames = ".1.3.6.1.6.3.15.1.1.3.0"
+
+	// snmpOIDWrongDigests is the usmStatsWrongDigests counter returned for authenticated requests
+	snmpOIDWrongDigests = ".1.3.6.1.6.3.15.1.1.5.0"
+)
+
+// snmpHashcatTypes maps the USM authentication parameter length to the hashcat hash type and mode
+var snmpHashcatTypes = map[int][2]string{
+	12: {"0", "25000"}, // HMAC-MD5-96 or HMAC-SHA1-96
+	16: {"3", "26700"}, // HMAC-SHA224-128
+	24: {"4", "26800"}, // HMAC-SHA256-192
+	32: {"5", "26900"}, // HMAC-SHA384-256
+	48: {"6", "27300"}, // HMAC-SHA512-384
+}
+
+// snmpV3Message holds the USM fields of a SNMPv3 message
+type snmpV3Message struct {
+	msgID       uint32
+	msgFlags    byte
+	secModel    uint32
+	engineID    []byte
+	engineBoots uint32
+	engineTime  uint32
+	userName    string
+	authParams  []byte
+	authOffset  int
+	requestID   uint32
 }
 
 // SpawnSNMP starts a logging SNMP server
 func SpawnSNMP(c *ConfSNMP) error {
+	if _, err := hex.DecodeString(c.EngineID); err != nil || c.EngineID == "" {
+		return fmt.Errorf("invalid snmp engine id %q", c.EngineID)
+	}
+
 	// Create the UDP listener
 	listener, err := net.ListenPacket("udp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort))
 	if err != nil {
@@ -67,6 +112,7 @@ func SpawnSNMP(c *ConfSNMP) error {
 
 	// Track the socket
 	c.listener = udpSocket
+	c.started = time.Now()
 
 	// Start the snmp handler
 	go snmpStart(c)
@@ -104,6 +150,11 @@ func snmpProcess(c *ConfSNMP, raddr net.Addr, data []byte) {
 }
 
 func snmpProcessData(c *ConfSNMP, raddr net.Addr, data []byte) {
+	if msg, err := snmpParseV3(data); err == nil {
+		snmpProcessV3(c, raddr, data, msg)
+		return
+	}
+
 	for _, decoder := range snmpDecoders {
 		res, err := decoder.SnmpDecodePacket(data)
 		if err != nil {
@@ -114,10 +165,6 @@ func snmpProcessData(c *ConfSNMP, raddr net.Addr, data []byte) {
 			continue
 		}
 
-		// TODO
-		// - Handle SNMP v3
-		// - Handle SNMP Traps
-
 		c.RecordWriter.Record("credential", "snmp", raddr.String(), map[string]string{
 			"community": res.Community,
 			"version":   res.Version.String(),
@@ -125,3 +172,253 @@ func snmpProcessData(c *ConfSNMP, raddr net.Addr, data []byte) {
 		})
 	}
 }
+
+// snmpProcessV3 records the user security model parameters and answers with a report
+func snmpProcessV3(c *ConfSNMP, raddr net.Addr, data []byte, msg *snmpV3Message) {
+	engineID, _ := hex.DecodeString(c.EngineID)
+
+	// Discovery requests have no user name and no authoritative engine ID
+	if msg.userName == "" {
+		snmpSendReport(c, raddr, msg, snmpOIDUnknownEngineIDs)
+		return
+	}
+
+	c.packets++
+
+	rec := map[string]string{
+		"_server":        fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+		"version":        gosnmp.Version3.String(),
+		"username":       msg.userName,
+		"engine_id":      hex.EncodeToString(msg.engineID),
+		"engine_boots":   fmt.Sprintf("%d", msg.engineBoots),
+		"engine_time":    fmt.Sprintf("%d", msg.engineTime),
+		"security_level": gosnmp.SnmpV3MsgFlags(msg.msgFlags & byte(gosnmp.AuthPriv)).String(),
+	}
+
+	if msg.msgFlags&byte(gosnmp.AuthNoPriv) == 0 {
+		c.RecordWriter.Record("access", "snmp", raddr.String(), rec)
+		snmpSendReport(c, raddr, msg, snmpOIDUnknownUserNames)
+		return
+	}
+
+	rec["auth_params"] = hex.EncodeToString(msg.authParams)
+
+	if hash, mode, ok := snmpV3Hashcat(data, msg, c.packets); ok {
+		rec["hashcat"] = hash
+		rec["hashcat_mode"] = mode
+	}
+
+	c.RecordWriter.Record("credential", "snmp", raddr.String(), rec)
+
+	// Requests for a different engine ID are sent back through discovery
+	if hex.EncodeToString(msg.engineID) != hex.EncodeToString(engineID) {
+		snmpSendReport(c, raddr, msg, snmpOIDUnknownEngineIDs)
+		return
+	}
+	snmpSendReport(c, raddr, msg, snmpOIDWrongDigests)
+}
+
+// snmpV3Hashcat builds the hashcat hash and mode for an authenticated message
+func snmpV3Hashcat(data []byte, msg *snmpV3Message, packet uint32) (string, string, bool) {
+	htype, ok := snmpHashcatTypes[len(msg.authParams)]
+	if !ok {
+		return "", "", false
+	}
+
+	// The HMAC covers the whole message with the authentication parameters zeroed
+	whole := make([]byte, len(data))
+	copy(whole, data)
+	for i := range msg.authParams {
+		whole[msg.authOffset+i] = 0
+	}
+	return fmt.Sprintf("$SNMPv3$%s$%d$%s$%s$%s", htype[0], packet, hex.EncodeToString(whole),
+		hex.EncodeToString(msg.engineID), hex.EncodeToString(msg.authParams)), htype[1], true
+}
+
+// snmpSendReport sends an unauthenticated report with the configured engine parameters
+func snmpSendReport(c *ConfSNMP, raddr net.Addr, msg *snmpV3Message, oid string) {
+	engineID, _ := hex.DecodeString(c.EngineID)
+	snmpWriteReport(c.listener, raddr, msg, engineID, c.EngineBoots, c.EngineTime+uint32(time.Since(c.started).Seconds()), oid)
+}
+
+// snmpWriteReport sends an unauthenticated report containing the engine parameters and a counter
+func snmpWriteReport(conn net.PacketConn, raddr net.Addr, msg *snmpV3Message, engineID []byte, boots uint32, etime uint32, oid string) {
+	if msg.msgFlags&byte(gosnmp.Reportable) == 0 {
+		return
+	}
+
+	report := &gosnmp.SnmpPacket{
+		Version:       gosnmp.Version3,
+		MsgID:         msg.msgID,
+		MsgFlags:      gosnmp.NoAuthNoPriv,
+		SecurityModel: gosnmp.UserSecurityModel,
+		SecurityParameters: &gosnmp.UsmSecurityParameters{
+			AuthoritativeEngineID:    string(engineID),
+			AuthoritativeEngineBoots: boots,
+			AuthoritativeEngineTime:  etime,
+			UserName:                 msg.userName,
+		},
+		ContextEngineID: string(engineID),
+		PDUType:         gosnmp.Report,
+		RequestID:       msg.requestID,
+		Variables: []gosnmp.SnmpPDU{
+			{Name: oid, Type: gosnmp.Counter32, Value: uint32(1)},
+		},
+	}
+
+	out, err := report.MarshalMsg()
+	if err != nil {
+		log.Debugf("snmp failed to marshal report for %s: %s", raddr.String(), err)
+		return
+	}
+	conn.WriteTo(out, raddr)
+}
+
+// snmpReadTLV returns the tag, the header length, and the value of the BER element at the start of data
+func snmpReadTLV(data []byte) (byte, int, []byte, error) {
+	if len(data) < 2 {
+		return 0, 0, nil, errors.New("truncated element")
+	}
+	tag := data[0]
+	size := int(data[1])
+	hlen := 2
+	if size&0x80 != 0 {
+		count := size & 0x7f
+		if count == 0 || count > 3 || len(data) < 2+count {
+			return 0, 0, nil, errors.New("invalid length")
+		}
+		size = 0
+		for _, b := range data[2 : 2+count] {
+			size = size<<8 | int(b)
+		}
+		hlen += count
+	}
+	if len(data) < hlen+size {
+		return 0, 0, nil, errors.New("truncated element")
+	}
+	return tag, hlen, data[hlen : hlen+size], nil
+}
+
+// snmpReadUint decodes a BER integer as an unsigned value
+func snmpReadUint(val []byte) uint32 {
+	res := uint32(0)
+	for _, b := range val {
+		res = res<<8 | uint32(b)
+	}
+	return res
+}
+
+// snmpParseV3 extracts the header and user security model parameters from a SNMPv3 message
+func snmpParseV3(data []byte) (*snmpV3Message, error) {
+	msg := &snmpV3Message{}
+
+	tag, hlen, body, err := snmpReadTLV(data)
+	if err != nil || tag != byte(gosnmp.Sequence) {
+		return nil, errors.New("not a snmp message")
+	}
+	offset := hlen
+
+	// Read the sequence of elements, tracking the offset into the original message
+	next := func(expected byte) ([]byte, int, error) {
+		tag, hlen, val, err := snmpReadTLV(body)
+		if err != nil {
+			return nil, 0, err
+		}
+		if tag != expected {
+			return nil, 0, fmt.Errorf("unexpected tag %d", tag)
+		}
+		start := offset + hlen
+		offset += hlen + len(val)
+		body = body[hlen+len(val):]
+		return val, start, nil
+	}
+
+	version, _, err := next(byte(gosnmp.Integer))
+	if err != nil || snmpReadUint(version) != uint32(gosnmp.Version3) {
+		return nil, errors.New("not a snmpv3 message")
+	}
+
+	header, _, err := next(byte(gosnmp.Sequence))
+	if err != nil {
+		return nil, err
+	}
+	secParams, secOffset, err := next(byte(gosnmp.OctetString))
+	if err != nil {
+		return nil, err
+	}
+	scoped := body
+
+	// Header: msgID, msgMaxSize, msgFlags, msgSecurityModel
+	body, offset = header, 0
+	msgID, _, err := next(byte(gosnmp.Integer))
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err = next(byte(gosnmp.Integer)); err != nil {
+		return nil, err
+	}
+	flags, _, err := next(byte(gosnmp.OctetString))
+	if err != nil || len(flags) != 1 {
+		return nil, errors.New("invalid msgFlags")
+	}
+	secModel, _, err := next(byte(gosnmp.Integer))
+	if err != nil {
+		return nil, err
+	}
+	msg.msgID = snmpReadUint(msgID)
+	msg.msgFlags = flags[0]
+	msg.secModel = snmpReadUint(secModel)
+	if msg.secModel != uint32(gosnmp.UserSecurityModel) {
+		return nil, fmt.Errorf("unsupported security model %d", msg.secModel)
+	}
+
+	// Security parameters: engine ID, boots, time, user name, authentication and privacy parameters
+	body, offset = secParams, secOffset
+	usm, usmOffset, err := next(byte(gosnmp.Sequence))
+	if err != nil {
+		return nil, err
+	}
+	body, offset = usm, usmOffset
+	if msg.engineID, _, err = next(byte(gosnmp.OctetString)); err != nil {
+		return nil, err
+	}
+	boots, _, err := next(byte(gosnmp.Integer))
+	if err != nil {
+		return nil, err
+	}
+	etime, _, err := next(byte(gosnmp.Integer))
+	if err != nil {
+		return nil, err
+	}
+	user, _, err := next(byte(gosnmp.OctetString))
+	if err != nil {
+		return nil, err
+	}
+	if msg.authParams, msg.authOffset, err = next(byte(gosnmp.OctetString)); err != nil {
+		return nil, err
+	}
+	msg.engineBoots = snmpReadUint(boots)
+	msg.engineTime = snmpReadUint(etime)
+	msg.userName = string(user)
+
+	// The request ID is only available when the scoped PDU is not encrypted
+	body, offset = scoped, 0
+	if len(body) > 0 && body[0] == byte(gosnmp.OctetString) {
+		return msg, nil
+	}
+	pdus, _, err := next(byte(gosnmp.Sequence))
+	if err != nil {
+		return msg, nil
+	}
+	body = pdus
+	next(byte(gosnmp.OctetString))
+	next(byte(gosnmp.OctetString))
+	if len(body) > 0 {
+		if _, _, pdu, err := snmpReadTLV(body); err == nil {
+			if _, _, reqID, err := snmpReadTLV(pdu); err == nil {
+				msg.requestID = snmpReadUint(reqID)
+			}
+		}
+	}
+	return msg, nil
+}
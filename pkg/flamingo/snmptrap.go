@@ -0,0 +1,276 @@
+package flamingo
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gosnmp/gosnmp"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// snmpOIDTrapOID is the snmpTrapOID.0 varbind that identifies SNMPv2 notifications
+	snmpOIDTrapOID = ".1.3.6.1.6.3.1.1.4.1.0"
+
+	// snmpOIDTrapEnterprise is the snmpTrapEnterprise.0 varbind sent with translated SNMPv1 traps
+	snmpOIDTrapEnterprise = ".1.3.6.1.6.3.1.1.4.3.0"
+)
+
+// snmpAuthProtocols maps the USM authentication parameter length to a protocol that gosnmp can parse
+var snmpAuthProtocols = map[int]gosnmp.SnmpV3AuthProtocol{
+	12: gosnmp.MD5,
+	16: gosnmp.SHA224,
+	24: gosnmp.SHA256,
+	32: gosnmp.SHA384,
+	48: gosnmp.SHA512,
+}
+
+// ConfSNMPTrap describes the options for a snmp trap receiver
+type ConfSNMPTrap struct {
+	BindPort     uint16
+	BindHost     string
+	EngineID     string
+	EngineBoots  uint32
+	EngineTime   uint32
+	RecordWriter *RecordWriter
+	shutdown     bool
+	listener     net.PacketConn
+	started      time.Time
+	packets      uint32
+	m            sync.Mutex
+}
+
+// IsShutdown checks to see if the service is shutting down
+func (c *ConfSNMPTrap) IsShutdown() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.shutdown
+}
+
+// Shutdown flags the service to shut down
+func (c *ConfSNMPTrap) Shutdown() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.shutdown {
+		return
+	}
+	c.shutdown = true
+	c.listener.Close()
+}
+
+// NewConfSNMPTrap creates a default configuration for the SNMP trap receiver
+func NewConfSNMPTrap() *ConfSNMPTrap {
+	return &ConfSNMPTrap{
+		BindPort:    162,
+		BindHost:    "[::]",
+		EngineID:    "80001f8880" + RandomHex(8),
+		EngineBoots: 1,
+	}
+}
+
+// SpawnSNMPTrap starts a logging SNMP trap receiver
+func SpawnSNMPTrap(c *ConfSNMPTrap) error {
+	if _, err := hex.DecodeString(c.EngineID); err != nil || c.EngineID == "" {
+		return fmt.Errorf("invalid snmp engine id %q", c.EngineID)
+	}
+
+	listener, err := net.ListenPacket("udp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s:%d (%s)", c.BindHost, c.BindPort, err)
+	}
+
+	c.listener = listener
+	c.started = time.Now()
+
+	go snmpTrapStart(c)
+
+	return nil
+}
+
+func snmpTrapStart(c *ConfSNMPTrap) {
+	log.Debugf("snmptrap is listening on %s:%d", c.BindHost, c.BindPort)
+
+	buff := make([]byte, 65535)
+	for {
+		if c.IsShutdown() {
+			log.Debugf("snmptrap server on %s:%d is shutting down", c.BindHost, c.BindPort)
+			break
+		}
+
+		rlen, raddr, rerr := c.listener.ReadFrom(buff)
+		if rerr != nil {
+			continue
+		}
+
+		data := buff[0:rlen]
+		snmpTrapProcess(c, raddr, data)
+	}
+}
+
+func snmpTrapProcess(c *ConfSNMPTrap, raddr net.Addr, data []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Debugf("snmptrap decoder panic with data %s: %q", hex.EncodeToString(data), r)
+		}
+	}()
+	snmpTrapProcessData(c, raddr, data)
+}
+
+func snmpTrapProcessData(c *ConfSNMPTrap, raddr net.Addr, data []byte) {
+	rec := map[string]string{
+		"_server": fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+	}
+
+	decoder := &gosnmp.GoSNMP{Version: gosnmp.Version2c}
+
+	msg, err := snmpParseV3(data)
+	if err == nil {
+		engineID, _ := hex.DecodeString(c.EngineID)
+
+		// Informs are sent to the receiver's engine, which senders discover first
+		if msg.userName == "" {
+			snmpWriteReport(c.listener, raddr, msg, engineID, c.EngineBoots, c.snmpEngineTime(), snmpOIDUnknownEngineIDs)
+			return
+		}
+
+		c.packets++
+		rec["username"] = msg.userName
+		rec["engine_id"] = hex.EncodeToString(msg.engineID)
+		rec["engine_boots"] = fmt.Sprintf("%d", msg.engineBoots)
+		rec["engine_time"] = fmt.Sprintf("%d", msg.engineTime)
+		rec["security_level"] = gosnmp.SnmpV3MsgFlags(msg.msgFlags & byte(gosnmp.AuthPriv)).String()
+		if msg.msgFlags&byte(gosnmp.AuthNoPriv) != 0 {
+			rec["auth_params"] = hex.EncodeToString(msg.authParams)
+			if hash, mode, ok := snmpV3Hashcat(data, msg, c.packets); ok {
+				rec["hashcat"] = hash
+				rec["hashcat_mode"] = mode
+			}
+		}
+
+		// The varbinds are readable without the user's keys unless privacy is in use, so
+		// the authentication protocol and key only need to be set for parsing to succeed
+		usm := &gosnmp.UsmSecurityParameters{UserName: msg.userName}
+		if proto, ok := snmpAuthProtocols[len(msg.authParams)]; ok && msg.msgFlags&byte(gosnmp.AuthNoPriv) != 0 {
+			usm.AuthenticationProtocol = proto
+			usm.SecretKey = []byte{0}
+		}
+		decoder = &gosnmp.GoSNMP{
+			Version:            gosnmp.Version3,
+			SecurityModel:      gosnmp.UserSecurityModel,
+			MsgFlags:           gosnmp.NoAuthNoPriv,
+			SecurityParameters: usm,
+		}
+	}
+
+	// Decoding modifies the authentication parameters in place
+	packet := make([]byte, len(data))
+	copy(packet, data)
+	res, err := decoder.UnmarshalTrap(packet, false)
+	if err != nil && msg == nil {
+		return
+	}
+
+	if res == nil || err != nil {
+		// Encrypted SNMPv3 notifications are recorded without the varbinds
+		rec["version"] = gosnmp.Version3.String()
+		c.RecordWriter.Record("access", "snmptrap", raddr.String(), rec)
+		if hex.EncodeToString(msg.engineID) == c.EngineID {
+			engineID, _ := hex.DecodeString(c.EngineID)
+			snmpWriteReport(c.listener, raddr, msg, engineID, c.EngineBoots, c.snmpEngineTime(), snmpOIDWrongDigests)
+		}
+		return
+	}
+
+	switch res.PDUType {
+	case gosnmp.Trap, gosnmp.SNMPv2Trap, gosnmp.InformRequest:
+	default:
+		return
+	}
+
+	rec["version"] = res.Version.String()
+	rec["pdu"] = res.PDUType.String()
+	if res.Version != gosnmp.Version3 {
+		rec["community"] = res.Community
+	}
+
+	// SNMPv1 traps carry the enterprise in the PDU, later versions use varbinds
+	if res.PDUType == gosnmp.Trap {
+		rec["enterprise"] = res.Enterprise
+		rec["agent_address"] = res.AgentAddress
+		rec["generic_trap"] = fmt.Sprintf("%d", res.GenericTrap)
+		rec["specific_trap"] = fmt.Sprintf("%d", res.SpecificTrap)
+	}
+
+	for _, v := range res.Variables {
+		val := snmpFormatValue(v)
+		switch v.Name {
+		case snmpOIDTrapOID:
+			rec["trap_oid"] = val
+			if rec["enterprise"] == "" {
+				rec["enterprise"] = val
+			}
+		case snmpOIDTrapEnterprise:
+			rec["enterprise"] = val
+		}
+		rec["varbind_"+strings.TrimPrefix(v.Name, ".")] = val
+	}
+
+	c.RecordWriter.Record("access", "snmptrap", raddr.String(), rec)
+
+	if res.PDUType != gosnmp.InformRequest {
+		return
+	}
+
+	// Authenticated informs cannot be acknowledged without the user's keys
+	if msg != nil {
+		if msg.msgFlags&byte(gosnmp.AuthNoPriv) != 0 {
+			engineID, _ := hex.DecodeString(c.EngineID)
+			snmpWriteReport(c.listener, raddr, msg, engineID, c.EngineBoots, c.snmpEngineTime(), snmpOIDWrongDigests)
+			return
+		}
+		res.ContextEngineID = string(msg.engineID)
+		res.SecurityParameters = &gosnmp.UsmSecurityParameters{
+			AuthoritativeEngineID:    string(msg.engineID),
+			AuthoritativeEngineBoots: msg.engineBoots,
+			AuthoritativeEngineTime:  msg.engineTime,
+			UserName:                 msg.userName,
+		}
+		res.MsgFlags = gosnmp.NoAuthNoPriv
+	}
+
+	// Acknowledge the inform so that the sender stops retrying
+	res.PDUType = gosnmp.GetResponse
+	out, err := res.MarshalMsg()
+	if err != nil {
+		log.Debugf("snmptrap failed to marshal inform response for %s: %s", raddr.String(), err)
+		return
+	}
+	c.listener.WriteTo(out, raddr)
+}
+
+// snmpEngineTime returns the engine time reported to clients
+func (c *ConfSNMPTrap) snmpEngineTime() uint32 {
+	return c.EngineTime + uint32(time.Since(c.started).Seconds())
+}
+
+// snmpFormatValue converts a varbind value to a printable string
+func snmpFormatValue(v gosnmp.SnmpPDU) string {
+	switch val := v.Value.(type) {
+	case nil:
+		return ""
+	case []byte:
+		if utf8.Valid(val) && !strings.ContainsAny(string(val), "\x00") {
+			return string(val)
+		}
+		return hex.EncodeToString(val)
+	case string:
+		return val
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
@@ -0,0 +1,292 @@
+package flamingo
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// socksMaxStringSize limits the length of the SOCKS4 user ID and SOCKS4a hostname
+const socksMaxStringSize = 255
+
+var socksCommandNames = map[byte]string{
+	0x01: "connect",
+	0x02: "bind",
+	0x03: "udp-associate",
+}
+
+// ConfSOCKS describes the options for a SOCKS service
+type ConfSOCKS struct {
+	BindPort     uint16
+	BindHost     string
+	RecordWriter *RecordWriter
+	shutdown     bool
+	listener     net.Listener
+	m            sync.Mutex
+}
+
+// IsShutdown checks to see if the service is shutting down
+func (c *ConfSOCKS) IsShutdown() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.shutdown
+}
+
+// Shutdown flags the service to shut down
+func (c *ConfSOCKS) Shutdown() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.shutdown = true
+	c.listener.Close()
+}
+
+// NewConfSOCKS creates a default configuration for the SOCKS capture server
+func NewConfSOCKS() *ConfSOCKS {
+	return &ConfSOCKS{
+		BindPort: 1080,
+		BindHost: "[::]",
+	}
+}
+
+// SpawnSOCKS starts a logging SOCKS server
+func SpawnSOCKS(c *ConfSOCKS) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s:%d (%s)", c.BindHost, c.BindPort, err)
+	}
+	c.listener = listener
+	go socksStart(c)
+	return nil
+}
+
+func socksStart(c *ConfSOCKS) {
+	log.Debugf("socks is listening on %s:%d", c.BindHost, c.BindPort)
+	for {
+		if c.IsShutdown() {
+			log.Debugf("socks server on %s:%d is shutting down", c.BindHost, c.BindPort)
+			break
+		}
+		conn, err := c.listener.Accept()
+		if err != nil {
+			// Triggers on shutdown, will break on reiteration of the loop
+			continue
+		}
+		go socksHandleConnection(c, conn)
+	}
+}
+
+func socksHandleConnection(c *ConfSOCKS, conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	ver, err := reader.ReadByte()
+	if err != nil {
+		return
+	}
+
+	switch ver {
+	case 0x04:
+		socksHandleV4(c, conn, reader)
+	case 0x05:
+		socksHandleV5(c, conn, reader)
+	}
+}
+
+// socksReadString reads a null-terminated string of up to socksMaxStringSize bytes
+func socksReadString(reader *bufio.Reader) (string, error) {
+	str := []byte{}
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == 0x00 {
+			return string(str), nil
+		}
+		if len(str) == socksMaxStringSize {
+			return "", fmt.Errorf("string exceeds %d bytes", socksMaxStringSize)
+		}
+		str = append(str, b)
+	}
+}
+
+func socksHandleV4(c *ConfSOCKS, conn net.Conn, reader *bufio.Reader) {
+	hdr := make([]byte, 7)
+	if _, err := io.ReadFull(reader, hdr); err != nil {
+		return
+	}
+
+	cmd := hdr[0]
+	port := binary.BigEndian.Uint16(hdr[1:3])
+	dest := net.IP(hdr[3:7]).String()
+
+	userID, err := socksReadString(reader)
+	if err != nil {
+		return
+	}
+
+	// SOCKS4a places the hostname after the user ID when the address is 0.0.0.x
+	version := "4"
+	if hdr[3] == 0 && hdr[4] == 0 && hdr[5] == 0 && hdr[6] != 0 {
+		version = "4a"
+		if dest, err = socksReadString(reader); err != nil {
+			return
+		}
+	}
+
+	rtype := "access"
+	if userID != "" {
+		rtype = "credential"
+	}
+
+	c.RecordWriter.Record(
+		rtype,
+		"socks",
+		conn.RemoteAddr().String(),
+		map[string]string{
+			"_server":     fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+			"version":     version,
+			"username":    userID,
+			"command":     socksCommandNames[cmd],
+			"destination": net.JoinHostPort(dest, strconv.Itoa(int(port))),
+			"method":      "userid",
+		},
+	)
+
+	// Request rejected or failed
+	conn.Write([]byte{0x00, 0x5b, 0, 0, 0, 0, 0, 0})
+}
+
+func socksHandleV5(c *ConfSOCKS, conn net.Conn, reader *bufio.Reader) {
+	nmethods, err := reader.ReadByte()
+	if err != nil || nmethods == 0 {
+		return
+	}
+
+	methods := make([]byte, nmethods)
+	if _, err := io.ReadFull(reader, methods); err != nil {
+		return
+	}
+
+	offered := []string{}
+	hasUserPass := false
+	for _, m := range methods {
+		offered = append(offered, fmt.Sprintf("0x%.2x", m))
+		if m == 0x02 {
+			hasUserPass = true
+		}
+	}
+
+	// Only username/password authentication is acceptable
+	if !hasUserPass {
+		c.RecordWriter.Record(
+			"access",
+			"socks",
+			conn.RemoteAddr().String(),
+			map[string]string{
+				"_server": fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+				"version": "5",
+				"methods": strings.Join(offered, ","),
+			},
+		)
+		conn.Write([]byte{0x05, 0xff})
+		return
+	}
+
+	if _, err := conn.Write([]byte{0x05, 0x02}); err != nil {
+		return
+	}
+
+	// RFC 1929 username/password sub-negotiation
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(reader, hdr); err != nil || hdr[0] != 0x01 {
+		return
+	}
+	username := make([]byte, hdr[1])
+	if _, err := io.ReadFull(reader, username); err != nil {
+		return
+	}
+	plen, err := reader.ReadByte()
+	if err != nil {
+		return
+	}
+	password := make([]byte, plen)
+	if _, err := io.ReadFull(reader, password); err != nil {
+		return
+	}
+
+	rec := map[string]string{
+		"_server":  fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+		"version":  "5",
+		"username": string(username),
+		"password": string(password),
+		"method":   "userpass",
+	}
+
+	// Accept the credentials so the client sends the requested destination
+	conn.Write([]byte{0x01, 0x00})
+	if cmd, dest, err := socksReadV5Request(reader); err == nil {
+		rec["command"] = socksCommandNames[cmd]
+		rec["destination"] = dest
+	}
+
+	c.RecordWriter.Record("credential", "socks", conn.RemoteAddr().String(), rec)
+
+	// Connection not allowed by ruleset
+	conn.Write([]byte{0x05, 0x02, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+}
+
+// socksReadV5Request reads a SOCKS5 request and returns the command and destination
+func socksReadV5Request(reader *bufio.Reader) (byte, string, error) {
+	hdr := make([]byte, 4)
+	if _, err := io.ReadFull(reader, hdr); err != nil {
+		return 0, "", err
+	}
+	if hdr[0] != 0x05 {
+		return 0, "", fmt.Errorf("invalid request version %d", hdr[0])
+	}
+
+	dest := ""
+	switch hdr[3] {
+	case 0x01:
+		addr := make([]byte, 4)
+		if _, err := io.ReadFull(reader, addr); err != nil {
+			return 0, "", err
+		}
+		dest = net.IP(addr).String()
+	case 0x03:
+		alen, err := reader.ReadByte()
+		if err != nil {
+			return 0, "", err
+		}
+		addr := make([]byte, alen)
+		if _, err := io.ReadFull(reader, addr); err != nil {
+			return 0, "", err
+		}
+		dest = string(addr)
+	case 0x04:
+		addr := make([]byte, 16)
+		if _, err := io.ReadFull(reader, addr); err != nil {
+			return 0, "", err
+		}
+		dest = net.IP(addr).String()
+	default:
+		return 0, "", fmt.Errorf("invalid address type %d", hdr[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(reader, port); err != nil {
+		return 0, "", err
+	}
+
+	return hdr[1], net.JoinHostPort(dest, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
@@ -0,0 +1,92 @@
+package flamingo
+
+import (
+	"bytes"
+	"fmt"
+
+	ber "github.com/atredispartners/flamingo/pkg/asn1-ber"
+)
+
+// spnegoOIDNTLMSSP is the DER-encoded NTLMSSP mechanism OID (1.3.6.1.4.1.311.2.2.10)
+var spnegoOIDNTLMSSP = []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0x82, 0x37, 0x02, 0x02, 0x0a}
+
+const (
+	spnegoStateAcceptCompleted  = 0
+	spnegoStateAcceptIncomplete = 1
+	spnegoStateReject           = 2
+)
+
+// spnegoToken describes the parts of a SPNEGO NegTokenInit or NegTokenResp we care about
+type spnegoToken struct {
+	// Wrapped is false when the client sent a raw NTLMSSP message
+	Wrapped bool
+	// OfferedNTLM indicates that NTLMSSP was listed in the mechanism types
+	OfferedNTLM bool
+	// NTLM holds the inner NTLMSSP message, if any
+	NTLM []byte
+}
+
+// spnegoUnwrap extracts the NTLMSSP message from a SPNEGO NegTokenInit or NegTokenResp
+func spnegoUnwrap(data []byte) (*spnegoToken, error) {
+	if bytes.HasPrefix(data, ntlmsspSignature) {
+		return &spnegoToken{NTLM: data, OfferedNTLM: true}, nil
+	}
+
+	if len(data) == 0 || (data[0] != 0x60 && data[0] != 0xa1) {
+		return nil, fmt.Errorf("not a spnego token")
+	}
+
+	pkt, err := ber.DecodePacket(data)
+	if err != nil {
+		return nil, err
+	}
+
+	tok := &spnegoToken{Wrapped: true}
+	spnegoWalk(pkt, tok)
+	return tok, nil
+}
+
+// spnegoWalk searches the decoded token for the NTLMSSP OID and message
+func spnegoWalk(pkt *ber.Packet, tok *spnegoToken) {
+	if pkt.ClassType == ber.ClassUniversal && pkt.TagType == ber.TypePrimitive {
+		switch pkt.Tag {
+		case ber.TagObjectIdentifier:
+			if bytes.Equal(pkt.Data.Bytes(), spnegoOIDNTLMSSP) {
+				tok.OfferedNTLM = true
+			}
+		case ber.TagOctetString:
+			if tok.NTLM == nil && bytes.HasPrefix(pkt.ByteValue, ntlmsspSignature) {
+				tok.NTLM = pkt.ByteValue
+			}
+		}
+	}
+
+	for _, child := range pkt.Children {
+		spnegoWalk(child, tok)
+	}
+}
+
+// spnegoWrapResponse creates a NegTokenResp selecting NTLMSSP, with an optional response token
+func spnegoWrapResponse(state uint64, token []byte) []byte {
+	negState := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "negState")
+	negState.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, state, "state"))
+
+	oid := ber.Encode(ber.ClassUniversal, ber.TypePrimitive, ber.TagObjectIdentifier, nil, "NTLMSSP")
+	oid.Data.Write(spnegoOIDNTLMSSP)
+	supportedMech := ber.Encode(ber.ClassContext, ber.TypeConstructed, 1, nil, "supportedMech")
+	supportedMech.AppendChild(oid)
+
+	seq := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "NegTokenResp")
+	seq.AppendChild(negState)
+	seq.AppendChild(supportedMech)
+
+	if len(token) > 0 {
+		responseToken := ber.Encode(ber.ClassContext, ber.TypeConstructed, 2, nil, "responseToken")
+		responseToken.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(token), "token"))
+		seq.AppendChild(responseToken)
+	}
+
+	resp := ber.Encode(ber.ClassContext, ber.TypeConstructed, 1, nil, "NegotiationToken")
+	resp.AppendChild(seq)
+	return resp.Bytes()
+}
@@ -9,6 +9,7 @@ import (
 	"net"
 	"strings"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
@@ -16,14 +17,18 @@ import (
 
 // ConfSSH describes the options for a ssh service
 type ConfSSH struct {
-	PrivateKey   string
-	BindPort     uint16
-	BindHost     string
-	RecordWriter *RecordWriter
-	ServerConfig *ssh.ServerConfig
-	shutdown     bool
-	listener     net.Listener
-	m            sync.Mutex
+	PrivateKey     string
+	BindPort       uint16
+	BindHost       string
+	RecordWriter   *RecordWriter
+	ServerConfig   *ssh.ServerConfig
+	Prompts        []string
+	Shell          bool
+	ShellHostname  string
+	SessionTimeout time.Duration
+	shutdown       bool
+	listener       net.Listener
+	m              sync.Mutex
 }
 
 // IsShutdown checks to see if the service is shutting down
@@ -49,6 +54,9 @@ func NewConfSSH() *ConfSSH {
 		ServerConfig: &ssh.ServerConfig{
 			ServerVersion: "SSH-2.0-OpenSSH_7.6p1",
 		},
+		Prompts:        []string{"Password: "},
+		ShellHostname:  "web01",
+		SessionTimeout: 2 * time.Minute,
 	}
 }
 
@@ -66,6 +74,9 @@ func getSSHHandlePassword(c *ConfSSH) func(ssh.ConnMetadata, []byte) (*ssh.Permi
 				"_server":  fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
 			},
 		)
+		if c.Shell {
+			return nil, nil
+		}
 		return nil, fmt.Errorf("password collected for %q", sshConn.User())
 	}
 }
@@ -85,10 +96,71 @@ func getSSHHandlePublic(c *ConfSSH) func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.
 				"_server":       fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
 			},
 		)
+		if c.Shell {
+			return nil, nil
+		}
 		return nil, fmt.Errorf("pubkey collected for %q", sshConn.User())
 	}
 }
 
+func getSSHHandleKeyboardInteractive(c *ConfSSH) func(ssh.ConnMetadata, ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	return func(sshConn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+		prompts := []string{}
+		echos := []bool{}
+		for _, prompt := range c.Prompts {
+			if !strings.HasSuffix(prompt, " ") {
+				prompt += " "
+			}
+			prompts = append(prompts, prompt)
+			echos = append(echos, false)
+		}
+
+		answers, err := client("", "", prompts, echos)
+		if err != nil {
+			return nil, err
+		}
+		if len(answers) == 0 {
+			return nil, fmt.Errorf("no answers from %q", sshConn.User())
+		}
+
+		rec := map[string]string{
+			"username": sshConn.User(),
+			"version":  string(sshConn.ClientVersion()),
+			"method":   "keyboard-interactive",
+			"_server":  fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+		}
+		for i, answer := range answers {
+			rec[sshPromptKey(rec, prompts[i], i)] = answer
+		}
+		c.RecordWriter.Record("credential", "ssh", sshConn.RemoteAddr().String(), rec)
+		if c.Shell {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("keyboard-interactive answers collected for %q", sshConn.User())
+	}
+}
+
+// sshPromptKey derives a record field name from a keyboard-interactive prompt, such as "verification_code"
+func sshPromptKey(rec map[string]string, prompt string, idx int) string {
+	key := strings.Trim(strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		}
+		return '_'
+	}, strings.TrimSpace(prompt)), "_")
+	for strings.Contains(key, "__") {
+		key = strings.Replace(key, "__", "_", -1)
+	}
+
+	if _, exists := rec[key]; key == "" || exists {
+		key = fmt.Sprintf("answer_%d", idx+1)
+	}
+	return key
+}
+
 // SpawnSSH starts a logging SSH server
 func SpawnSSH(c *ConfSSH) error {
 
@@ -104,6 +176,9 @@ func SpawnSSH(c *ConfSSH) error {
 	c.ServerConfig.AddHostKey(pk)
 	c.ServerConfig.PasswordCallback = getSSHHandlePassword(c)
 	c.ServerConfig.PublicKeyCallback = getSSHHandlePublic(c)
+	if len(c.Prompts) > 0 {
+		c.ServerConfig.KeyboardInteractiveCallback = getSSHHandleKeyboardInteractive(c)
+	}
 
 	// Create the TCP listener
 	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort))
@@ -139,16 +214,27 @@ func sshHandleConn(tcpConn net.Conn, c *ConfSSH) {
 	// Ensure the socket is closed
 	defer tcpConn.Close()
 
+	// Limit the lifetime of sessions that are allowed to log in
+	if c.Shell && c.SessionTimeout > 0 {
+		tcpConn.SetDeadline(time.Now().Add(c.SessionTimeout))
+	}
+
 	// Negotiate the session
-	sshConn, _, _, err := ssh.NewServerConn(tcpConn, c.ServerConfig)
+	sshConn, chans, reqs, err := ssh.NewServerConn(tcpConn, c.ServerConfig)
 	if err != nil {
 		return
 	}
 
-	// Until we support authenticated sessions, this is unused
-
 	// Ensure the ssh session is closed
 	defer sshConn.Close()
+
+	if !c.Shell {
+		return
+	}
+
+	// Serve the fake shell until the client disconnects or the session times out
+	go ssh.DiscardRequests(reqs)
+	sshHandleChannels(c, sshConn, chans)
 }
 
 // SSHGenerateRSAKey generates a new SSH host key
@@ -0,0 +1,378 @@
+package flamingo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshErrInterrupt is returned when the client sends Ctrl-C while a line is being read
+var sshErrInterrupt = errors.New("interrupted")
+
+// sshSession serves the scripted shell and exec responder for an accepted session channel
+type sshSession struct {
+	c      *ConfSSH
+	conn   *ssh.ServerConn
+	ch     ssh.Channel
+	pty    bool
+	skipLF bool
+}
+
+// sshHandleChannels accepts session channels from an authenticated client
+func sshHandleChannels(c *ConfSSH, sshConn *ssh.ServerConn, chans <-chan ssh.NewChannel) {
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		ch, reqs, err := newChan.Accept()
+		if err != nil {
+			continue
+		}
+		s := &sshSession{c: c, conn: sshConn, ch: ch}
+		go s.handleRequests(reqs)
+	}
+}
+
+func (s *sshSession) handleRequests(reqs <-chan *ssh.Request) {
+	started := false
+	for req := range reqs {
+		switch req.Type {
+		case "pty-req":
+			// The shell reads the pty flag once started, so it cannot change afterwards
+			if started {
+				req.Reply(false, nil)
+				continue
+			}
+			s.pty = true
+			req.Reply(true, nil)
+		case "env", "window-change":
+			req.Reply(true, nil)
+		case "shell":
+			if started {
+				req.Reply(false, nil)
+				continue
+			}
+			started = true
+			req.Reply(true, nil)
+			go s.shell()
+		case "exec":
+			var payload struct{ Command string }
+			if started || ssh.Unmarshal(req.Payload, &payload) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			started = true
+			req.Reply(true, nil)
+			go s.exec(payload.Command)
+		default:
+			// Subsystems such as sftp are not offered
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// record writes an access record for a command run by the client
+func (s *sshSession) record(method string, command string) {
+	s.c.RecordWriter.Record("access", "ssh", s.conn.RemoteAddr().String(), map[string]string{
+		"username": s.conn.User(),
+		"version":  string(s.conn.ClientVersion()),
+		"method":   method,
+		"command":  command,
+		"_server":  fmt.Sprintf("%s:%d", s.c.BindHost, s.c.BindPort),
+	})
+}
+
+// recordPassword writes a credential record for a password sent over the channel
+func (s *sshSession) recordPassword(method string, command string, password string) {
+	s.c.RecordWriter.Record("credential", "ssh", s.conn.RemoteAddr().String(), map[string]string{
+		"username": s.conn.User(),
+		"password": password,
+		"version":  string(s.conn.ClientVersion()),
+		"method":   method,
+		"command":  command,
+		"_server":  fmt.Sprintf("%s:%d", s.c.BindHost, s.c.BindPort),
+	})
+}
+
+// write sends output to the client, translating newlines for terminals
+func (s *sshSession) write(w io.Writer, msg string) error {
+	if s.pty {
+		msg = strings.Replace(msg, "\n", "\r\n", -1)
+	}
+	_, err := w.Write([]byte(msg))
+	return err
+}
+
+// readLine reads a line of input, echoing it back to terminals if requested
+func (s *sshSession) readLine(echo bool) (string, error) {
+	line := []byte{}
+	escape := false
+	b := make([]byte, 1)
+	for len(line) < 4096 {
+		if _, err := s.ch.Read(b); err != nil {
+			if len(line) > 0 {
+				return string(line), nil
+			}
+			return "", err
+		}
+
+		// Lines may end with CR, LF, or CRLF
+		if b[0] == '\n' && s.skipLF {
+			s.skipLF = false
+			continue
+		}
+		s.skipLF = b[0] == '\r'
+
+		// Skip terminal escape sequences such as arrow keys
+		if escape {
+			if (b[0] >= 'A' && b[0] <= 'Z') || (b[0] >= 'a' && b[0] <= 'z') || b[0] == '~' {
+				escape = false
+			}
+			continue
+		}
+
+		switch b[0] {
+		case '\r', '\n':
+			if s.pty {
+				s.ch.Write([]byte("\r\n"))
+			}
+			return string(line), nil
+		case 0x7f, 0x08:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				if echo && s.pty {
+					s.ch.Write([]byte("\b \b"))
+				}
+			}
+		case 0x03:
+			if s.pty {
+				s.ch.Write([]byte("^C\r\n"))
+			}
+			return "", sshErrInterrupt
+		case 0x04:
+			if len(line) == 0 {
+				return "", io.EOF
+			}
+		case 0x1b:
+			escape = true
+		default:
+			if b[0] < 0x20 {
+				continue
+			}
+			line = append(line, b[0])
+			if echo && s.pty {
+				s.ch.Write(b)
+			}
+		}
+	}
+	return string(line), nil
+}
+
+// exitStatus reports the exit code of the shell or command before the channel is closed
+func (s *sshSession) exitStatus(status uint32) {
+	s.ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+}
+
+func (s *sshSession) shell() {
+	defer s.ch.Close()
+
+	prompt := fmt.Sprintf("%s@%s:~$ ", s.conn.User(), s.c.ShellHostname)
+	if s.conn.User() == "root" {
+		prompt = fmt.Sprintf("root@%s:~# ", s.c.ShellHostname)
+	}
+
+	for {
+		if err := s.write(s.ch, prompt); err != nil {
+			return
+		}
+		line, err := s.readLine(true)
+		if err == sshErrInterrupt {
+			continue
+		}
+		if err != nil {
+			break
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		s.record("shell", line)
+		if exit, _ := s.run(line, s.ch); exit {
+			break
+		}
+	}
+	s.write(s.ch, "logout\n")
+	s.exitStatus(0)
+}
+
+func (s *sshSession) exec(command string) {
+	defer s.ch.Close()
+
+	s.record("exec", command)
+	_, status := s.run(command, s.ch.Stderr())
+	s.exitStatus(status)
+}
+
+// run answers a command with scripted output, returning whether the shell should exit and the exit status
+func (s *sshSession) run(line string, stderr io.Writer) (bool, uint32) {
+	args := sshSplitCommand(line)
+	if len(args) == 0 {
+		return false, 0
+	}
+
+	user := s.conn.User()
+	home := "/home/" + user
+	if user == "root" {
+		home = "/root"
+	}
+
+	switch path.Base(args[0]) {
+	case "exit", "logout", "quit":
+		return true, 0
+	case "sudo":
+		return false, s.sudo(line, args, stderr)
+	case "su":
+		s.readPassword("su", line, "Password: ", stderr)
+		s.write(stderr, "su: Authentication failure\n")
+		return false, 1
+	case "enable":
+		s.readPassword("enable", line, "Password: ", stderr)
+		s.write(stderr, "% Access denied\n")
+		return false, 1
+	case "whoami":
+		s.write(s.ch, user+"\n")
+	case "id":
+		if user == "root" {
+			s.write(s.ch, "uid=0(root) gid=0(root) groups=0(root)\n")
+		} else {
+			s.write(s.ch, fmt.Sprintf("uid=1000(%s) gid=1000(%s) groups=1000(%s),27(sudo)\n", user, user, user))
+		}
+	case "hostname":
+		s.write(s.ch, s.c.ShellHostname+"\n")
+	case "uname":
+		if len(args) > 1 && strings.Contains(args[1], "a") {
+			s.write(s.ch, fmt.Sprintf("Linux %s 5.15.0-91-generic #101-Ubuntu SMP Tue Nov 14 13:30:08 UTC 2023 x86_64 x86_64 x86_64 GNU/Linux\n", s.c.ShellHostname))
+		} else {
+			s.write(s.ch, "Linux\n")
+		}
+	case "pwd":
+		s.write(s.ch, home+"\n")
+	case "echo":
+		s.write(s.ch, strings.Join(args[1:], " ")+"\n")
+	case "cd", "clear", "export", "ls", "true", "unset":
+	default:
+		s.write(stderr, fmt.Sprintf("-bash: %s: command not found\n", args[0]))
+		return false, 127
+	}
+	return false, 0
+}
+
+// sudo prompts for the user's password like sudo, including custom prompts set with -p
+func (s *sshSession) sudo(line string, args []string, stderr io.Writer) uint32 {
+	prompt := fmt.Sprintf("[sudo] password for %s: ", s.conn.User())
+	stdin := false
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-n" || arg == "--non-interactive":
+			s.write(stderr, "sudo: a password is required\n")
+			return 1
+		case arg == "-S" || arg == "--stdin":
+			stdin = true
+		case arg == "-p" && i+1 < len(args):
+			prompt = args[i+1]
+			i++
+		case arg == "-u" || arg == "-g" || arg == "-C" || arg == "-D" || arg == "-h" || arg == "-r" || arg == "-t" || arg == "-U":
+			i++
+		case strings.HasPrefix(arg, "--prompt="):
+			prompt = strings.TrimPrefix(arg, "--prompt=")
+		case strings.HasPrefix(arg, "-p"):
+			prompt = arg[2:]
+		case !strings.HasPrefix(arg, "-"):
+			i = len(args)
+		}
+	}
+
+	// Passwords read from standard input are only sent once
+	attempts := 3
+	if stdin {
+		attempts = 1
+	}
+	for i := 0; i < attempts; i++ {
+		if !s.readPassword("sudo", line, prompt, stderr) {
+			return 1
+		}
+		if i+1 < attempts {
+			s.write(stderr, "Sorry, try again.\n")
+		}
+	}
+	if attempts == 1 {
+		s.write(stderr, "sudo: 1 incorrect password attempt\n")
+	} else {
+		s.write(stderr, fmt.Sprintf("sudo: %d incorrect password attempts\n", attempts))
+	}
+	return 1
+}
+
+// readPassword prompts for and records a password, returning false if the client disconnected
+func (s *sshSession) readPassword(method string, command string, prompt string, stderr io.Writer) bool {
+	if err := s.write(stderr, prompt); err != nil {
+		return false
+	}
+	password, err := s.readLine(false)
+	if err != nil {
+		return false
+	}
+	if password != "" {
+		s.recordPassword(method, command, password)
+	}
+	return true
+}
+
+// sshSplitCommand splits a command line into words, honoring quotes and backslash escapes
+func sshSplitCommand(line string) []string {
+	args := []string{}
+	word := []rune{}
+	inWord := false
+	var quote rune
+	escaped := false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			word = append(word, r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+				continue
+			}
+			word = append(word, r)
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				args = append(args, string(word))
+				word = word[:0]
+				inWord = false
+			}
+		default:
+			word = append(word, r)
+			inWord = true
+		}
+	}
+	if inWord {
+		args = append(args, string(word))
+	}
+	return args
+}
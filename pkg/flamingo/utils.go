@@ -1,7 +1,10 @@
 package flamingo
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 )
@@ -67,3 +70,98 @@ func CrackPorts(pspec string) ([]int, error) {
 	}
 	return results, nil
 }
+
+// ParseAuthParams splits a HTTP-style auth-param list (key=value, key="value") into a map
+func ParseAuthParams(data string) map[string]string {
+	res := make(map[string]string)
+	idx := 0
+	for idx < len(data) {
+		// Skip separators and whitespace
+		for idx < len(data) && (data[idx] == ',' || data[idx] == ' ' || data[idx] == '\t') {
+			idx++
+		}
+
+		// Read the key
+		kstart := idx
+		for idx < len(data) && data[idx] != '=' && data[idx] != ',' {
+			idx++
+		}
+		key := strings.ToLower(strings.TrimSpace(data[kstart:idx]))
+		if idx >= len(data) || data[idx] == ',' {
+			if key != "" {
+				res[key] = ""
+			}
+			continue
+		}
+		idx++
+
+		// Read the value, handling quoted strings with escapes
+		val := ""
+		if idx < len(data) && data[idx] == '"' {
+			idx++
+			var sb strings.Builder
+			for idx < len(data) && data[idx] != '"' {
+				if data[idx] == '\\' && idx+1 < len(data) {
+					idx++
+				}
+				sb.WriteByte(data[idx])
+				idx++
+			}
+			idx++
+			val = sb.String()
+		} else {
+			vstart := idx
+			for idx < len(data) && data[idx] != ',' {
+				idx++
+			}
+			val = strings.TrimSpace(data[vstart:idx])
+		}
+
+		if key != "" {
+			res[key] = val
+		}
+	}
+	return res
+}
+
+// DigestToHashcat converts a MD5 digest authentication response to hashcat mode 11400 format
+func DigestToHashcat(serverHost string, raddr string, method string, digest map[string]string) string {
+	clientHost, _, err := net.SplitHostPort(raddr)
+	if err != nil {
+		clientHost = raddr
+	}
+
+	// The digest URI is rebuilt as prefix:resource, so keep everything after the scheme together
+	uriPrefix := ""
+	uriResource := digest["uri"]
+	if ubits := strings.SplitN(digest["uri"], ":", 2); len(ubits) == 2 {
+		uriPrefix = ubits[0]
+		uriResource = ubits[1]
+	}
+
+	// The request method in the digest must match the one used by the client
+	return strings.Join([]string{
+		"$sip$",
+		serverHost,
+		clientHost,
+		digest["username"],
+		digest["realm"],
+		method,
+		uriPrefix,
+		uriResource,
+		"",
+		digest["nonce"],
+		digest["cnonce"],
+		digest["nc"],
+		digest["qop"],
+		"MD5",
+		digest["response"],
+	}, "*")
+}
+
+// RandomHex returns a random hex string of the specified byte length
+func RandomHex(size int) string {
+	buff := make([]byte, size)
+	rand.Read(buff)
+	return hex.EncodeToString(buff)
+}
@@ -0,0 +1,54 @@
+package flamingo
+
+import "testing"
+
+func TestDigestToHashcat(t *testing.T) {
+	tests := []struct {
+		name   string
+		server string
+		raddr  string
+		method string
+		digest map[string]string
+		want   string
+	}{
+		{
+			// The example hash for hashcat mode 11400, with the password hashcat
+			"hashcat example",
+			"192.168.100.100",
+			"192.168.100.121:5060",
+			"REGISTER",
+			map[string]string{
+				"username": "username",
+				"realm":    "asterisk",
+				"uri":      "sip:192.168.100.121",
+				"nonce":    "2b01df0b",
+				"response": "ad0520061ca07c120d7e8ce696a6df2d",
+			},
+			"$sip$*192.168.100.100*192.168.100.121*username*asterisk*REGISTER*sip*192.168.100.121**2b01df0b****MD5*ad0520061ca07c120d7e8ce696a6df2d",
+		},
+		{
+			// qop=auth response for alice with the password hashcat
+			"qop auth",
+			"10.0.0.5",
+			"[2001:db8::1]:5060",
+			"INVITE",
+			map[string]string{
+				"username": "alice",
+				"realm":    "corp.local",
+				"uri":      "sip:100@10.0.0.5",
+				"nonce":    "5f3a",
+				"cnonce":   "0a4f113b",
+				"nc":       "00000001",
+				"qop":      "auth",
+				"response": "aebab04e2aa5e10e8171afa39fa4e7d0",
+			},
+			"$sip$*10.0.0.5*2001:db8::1*alice*corp.local*INVITE*sip*100@10.0.0.5**5f3a*0a4f113b*00000001*auth*MD5*aebab04e2aa5e10e8171afa39fa4e7d0",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := DigestToHashcat(tt.server, tt.raddr, tt.method, tt.digest); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
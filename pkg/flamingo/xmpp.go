@@ -0,0 +1,363 @@
+package flamingo
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	xmppNSStream = "http://etherx.jabber.org/streams"
+	xmppNSTLS    = "urn:ietf:params:xml:ns:xmpp-tls"
+	xmppNSSASL   = "urn:ietf:params:xml:ns:xmpp-sasl"
+
+	// xmppScramIterations is the iteration count offered in SCRAM challenges
+	xmppScramIterations = 4096
+)
+
+// ConfXMPP describes the options for a XMPP service
+type ConfXMPP struct {
+	BindPort     uint16
+	BindHost     string
+	RecordWriter *RecordWriter
+	TLSName      string
+	TLSCert      string
+	TLSKey       string
+	shutdown     bool
+	listener     net.Listener
+	m            sync.Mutex
+}
+
+// xmppElement holds the parts of a top-level stream element used during authentication
+type xmppElement struct {
+	XMLName   xml.Name
+	Mechanism string `xml:"mechanism,attr"`
+	Body      string `xml:",chardata"`
+}
+
+// xmppSession tracks the stream and SASL state for a client connection
+type xmppSession struct {
+	conn      net.Conn
+	decoder   *xml.Decoder
+	secure    bool
+	domain    string
+	mechanism string
+	nonce     string
+	salt      string
+	username  string
+	scram     string
+}
+
+// IsShutdown checks to see if the service is shutting down
+func (c *ConfXMPP) IsShutdown() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.shutdown
+}
+
+// Shutdown flags the service to shut down
+func (c *ConfXMPP) Shutdown() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.shutdown = true
+	c.listener.Close()
+}
+
+// NewConfXMPP creates a default configuration for the XMPP capture server
+func NewConfXMPP() *ConfXMPP {
+	return &ConfXMPP{
+		BindPort: 5222,
+		BindHost: "[::]",
+	}
+}
+
+// SpawnXMPP starts a logging XMPP server
+func SpawnXMPP(c *ConfXMPP) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", c.BindHost, c.BindPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s:%d (%s)", c.BindHost, c.BindPort, err)
+	}
+	c.listener = listener
+	go xmppStart(c)
+	return nil
+}
+
+func xmppStart(c *ConfXMPP) {
+	log.Debugf("xmpp is listening on %s:%d", c.BindHost, c.BindPort)
+	for {
+		if c.IsShutdown() {
+			log.Debugf("xmpp server on %s:%d is shutting down", c.BindHost, c.BindPort)
+			break
+		}
+		conn, err := c.listener.Accept()
+		if err != nil {
+			// Triggers on shutdown, will break on reiteration of the loop
+			continue
+		}
+		go xmppHandleConnection(c, conn)
+	}
+}
+
+// xmppEscape escapes a string for use in an attribute value
+func xmppEscape(val string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(val))
+	return b.String()
+}
+
+// write sends raw XML to the client
+func (s *xmppSession) write(data string) error {
+	_, err := s.conn.Write([]byte(data))
+	return err
+}
+
+// xmppOpenStream waits for the client stream header and sends the server header and features
+func xmppOpenStream(c *ConfXMPP, s *xmppSession) error {
+	s.decoder = xml.NewDecoder(s.conn)
+	for {
+		tok, err := s.decoder.Token()
+		if err != nil {
+			return err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Space != xmppNSStream || start.Name.Local != "stream" {
+			return fmt.Errorf("unexpected element %s", start.Name.Local)
+		}
+		for _, attr := range start.Attr {
+			if attr.Name.Local == "to" {
+				s.domain = attr.Value
+			}
+		}
+		break
+	}
+
+	from := s.domain
+	if from == "" {
+		from = c.TLSName
+	}
+
+	features := "<stream:features>"
+	if !s.secure {
+		features += "<starttls xmlns='" + xmppNSTLS + "'/>"
+	}
+	features += "<mechanisms xmlns='" + xmppNSSASL + "'>" +
+		"<mechanism>SCRAM-SHA-1</mechanism>" +
+		"<mechanism>DIGEST-MD5</mechanism>" +
+		"<mechanism>PLAIN</mechanism>" +
+		"</mechanisms></stream:features>"
+
+	return s.write(fmt.Sprintf("<?xml version='1.0'?><stream:stream xmlns='jabber:client' xmlns:stream='%s' id='%s' from='%s' version='1.0' xml:lang='en'>%s",
+		xmppNSStream, RandomHex(8), xmppEscape(from), features))
+}
+
+// xmppNextElement reads the next top-level element from the stream
+func xmppNextElement(s *xmppSession) (*xmppElement, error) {
+	for {
+		tok, err := s.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			elem := &xmppElement{}
+			if err := s.decoder.DecodeElement(elem, &t); err != nil {
+				return nil, err
+			}
+			return elem, nil
+		case xml.EndElement:
+			return nil, fmt.Errorf("stream closed")
+		}
+	}
+}
+
+func xmppHandleConnection(c *ConfXMPP, conn net.Conn) {
+	s := &xmppSession{conn: conn}
+	defer func() { s.conn.Close() }()
+
+	s.conn.SetDeadline(time.Now().Add(60 * time.Second))
+	if err := xmppOpenStream(c, s); err != nil {
+		return
+	}
+
+	for {
+		elem, err := xmppNextElement(s)
+		if err != nil {
+			return
+		}
+
+		switch {
+		case elem.XMLName.Space == xmppNSTLS && elem.XMLName.Local == "starttls" && !s.secure:
+			if err := s.write("<proceed xmlns='" + xmppNSTLS + "'/>"); err != nil {
+				return
+			}
+			tlsConn, err := xmppStartTLS(c, s.conn)
+			if err != nil {
+				log.Debugf("xmpp failed to start tls with %s: %s", s.conn.RemoteAddr().String(), err)
+				return
+			}
+			s.conn = tlsConn
+			s.secure = true
+			s.conn.SetDeadline(time.Now().Add(60 * time.Second))
+			if err := xmppOpenStream(c, s); err != nil {
+				return
+			}
+
+		case elem.XMLName.Space == xmppNSSASL && elem.XMLName.Local == "auth":
+			s.mechanism = strings.ToUpper(elem.Mechanism)
+			if !xmppProcessSASL(c, s, elem.Body, true) {
+				return
+			}
+
+		case elem.XMLName.Space == xmppNSSASL && elem.XMLName.Local == "response":
+			if !xmppProcessSASL(c, s, elem.Body, false) {
+				return
+			}
+
+		case elem.XMLName.Space == xmppNSSASL && elem.XMLName.Local == "abort":
+			s.write("<failure xmlns='" + xmppNSSASL + "'><aborted/></failure>")
+			s.mechanism = ""
+
+		default:
+			// Stanzas sent before authentication are not allowed
+			s.write("<stream:error><not-authorized xmlns='urn:ietf:params:xml:ns:xmpp-streams'/></stream:error></stream:stream>")
+			return
+		}
+	}
+}
+
+// xmppStartTLS upgrades the connection using the configured certificate
+func xmppStartTLS(c *ConfXMPP, conn net.Conn) (net.Conn, error) {
+	kp, err := tls.X509KeyPair([]byte(c.TLSCert), []byte(c.TLSKey))
+	if err != nil {
+		return nil, err
+	}
+	tlsConn := tls.Server(conn, &tls.Config{ServerName: c.TLSName, Certificates: []tls.Certificate{kp}})
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// xmppProcessSASL handles an auth or response element, returning false once the exchange is over
+func xmppProcessSASL(c *ConfXMPP, s *xmppSession, body string, initial bool) bool {
+	data := []byte{}
+	body = strings.TrimSpace(body)
+	if body != "" && body != "=" {
+		raw, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			s.write("<failure xmlns='" + xmppNSSASL + "'><incorrect-encoding/></failure>")
+			return false
+		}
+		data = raw
+	}
+
+	rec := map[string]string{
+		"_server":   fmt.Sprintf("%s:%d", c.BindHost, c.BindPort),
+		"domain":    s.domain,
+		"method":    strings.ToLower(s.mechanism),
+		"transport": "plain",
+	}
+	if s.secure {
+		rec["transport"] = "tls"
+	}
+
+	switch s.mechanism {
+	case "PLAIN":
+		// The credentials may be sent after an empty challenge
+		if initial && len(data) == 0 {
+			return s.write("<challenge xmlns='"+xmppNSSASL+"'/>") == nil
+		}
+		parts := strings.SplitN(string(data), "\x00", 3)
+		if len(parts) != 3 {
+			break
+		}
+		if parts[0] != "" {
+			rec["authzid"] = parts[0]
+		}
+		rec["username"] = parts[1]
+		rec["password"] = parts[2]
+		c.RecordWriter.Record("credential", "xmpp", s.conn.RemoteAddr().String(), rec)
+
+	case "DIGEST-MD5":
+		if initial {
+			s.nonce = base64.StdEncoding.EncodeToString([]byte(RandomHex(16)))
+			challenge := fmt.Sprintf(`realm="%s",nonce="%s",qop="auth",charset=utf-8,algorithm=md5-sess`, s.domain, s.nonce)
+			return xmppSendChallenge(s, challenge)
+		}
+		digest := ParseAuthParams(string(data))
+		if digest["username"] == "" || digest["response"] == "" {
+			break
+		}
+		for _, k := range []string{"username", "realm", "nonce", "cnonce", "nc", "qop", "digest-uri", "response", "authzid"} {
+			if digest[k] != "" {
+				rec[strings.ReplaceAll(k, "-", "_")] = digest[k]
+			}
+		}
+		c.RecordWriter.Record("credential", "xmpp", s.conn.RemoteAddr().String(), rec)
+
+	case "SCRAM-SHA-1":
+		if initial {
+			// The client-first message is "gs2-header,n=user,r=nonce"
+			parts := strings.SplitN(string(data), ",", 3)
+			if len(parts) != 3 {
+				break
+			}
+			clientFirst := parts[2]
+			attrs := xmppScramAttributes(clientFirst)
+			if attrs["n"] == "" || attrs["r"] == "" {
+				break
+			}
+			s.username = strings.NewReplacer("=2C", ",", "=3D", "=").Replace(attrs["n"])
+			s.salt = base64.StdEncoding.EncodeToString([]byte(RandomHex(8)))
+			serverFirst := fmt.Sprintf("r=%s%s,s=%s,i=%d", attrs["r"], RandomHex(12), s.salt, xmppScramIterations)
+			s.scram = clientFirst + "," + serverFirst
+			return xmppSendChallenge(s, serverFirst)
+		}
+
+		// The proof is the last attribute of the client-final message
+		clientFinal := string(data)
+		idx := strings.LastIndex(clientFinal, ",p=")
+		if idx < 0 || s.scram == "" {
+			break
+		}
+		rec["username"] = s.username
+		rec["salt"] = s.salt
+		rec["iterations"] = fmt.Sprintf("%d", xmppScramIterations)
+		rec["auth_message"] = s.scram + "," + clientFinal[:idx]
+		rec["proof"] = clientFinal[idx+3:]
+		c.RecordWriter.Record("credential", "xmpp", s.conn.RemoteAddr().String(), rec)
+
+	default:
+		s.write("<failure xmlns='" + xmppNSSASL + "'><invalid-mechanism/></failure>")
+		return true
+	}
+
+	s.write("<failure xmlns='" + xmppNSSASL + "'><not-authorized/></failure></stream:stream>")
+	return false
+}
+
+// xmppSendChallenge sends a base64-encoded SASL challenge
+func xmppSendChallenge(s *xmppSession, challenge string) bool {
+	return s.write("<challenge xmlns='"+xmppNSSASL+"'>"+base64.StdEncoding.EncodeToString([]byte(challenge))+"</challenge>") == nil
+}
+
+// xmppScramAttributes splits a SCRAM message into its single-letter attributes
+func xmppScramAttributes(msg string) map[string]string {
+	attrs := make(map[string]string)
+	for _, part := range strings.Split(msg, ",") {
+		if len(part) > 2 && part[1] == '=' {
+			attrs[part[0:1]] = part[2:]
+		}
+	}
+	return attrs
+}
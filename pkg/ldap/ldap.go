@@ -153,6 +153,8 @@ var LDAPResultCodeMap = map[LDAPResultCode]string{
 const (
 	LDAPBindAuthSimple = 0
 	LDAPBindAuthSASL   = 3
+
+	LDAPStartTLSOID = "1.3.6.1.4.1.1466.20037"
 )
 
 type LDAPResultCode uint8
@@ -0,0 +1,109 @@
+package ldap
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ParseLDIF reads the entries from LDIF content records, ignoring comments and the version line
+func ParseLDIF(r io.Reader) ([]*Entry, error) {
+	entries := []*Entry{}
+	lines := []string{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNum := 0
+	for {
+		more := scanner.Scan()
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if !more {
+			line = ""
+		}
+		lineNum++
+
+		switch {
+		case strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, " ") && len(lines) > 0:
+			// Continuation lines are folded into the previous line
+			lines[len(lines)-1] += line[1:]
+			continue
+		case line != "":
+			lines = append(lines, line)
+			continue
+		}
+
+		// A blank line or the end of input finishes the current record
+		if len(lines) > 0 {
+			entry, err := parseLDIFRecord(lines)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNum, err)
+			}
+			if entry != nil {
+				entries = append(entries, entry)
+			}
+			lines = lines[:0]
+		}
+		if !more {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func parseLDIFRecord(lines []string) (*Entry, error) {
+	var entry *Entry
+	for _, line := range lines {
+		bits := strings.SplitN(line, ":", 2)
+		if len(bits) != 2 {
+			return nil, fmt.Errorf("invalid attribute line %q", line)
+		}
+		name := strings.TrimSpace(bits[0])
+		value := bits[1]
+
+		// Values starting with a colon are base64 encoded
+		if strings.HasPrefix(value, ":") {
+			raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid base64 value for %s", name)
+			}
+			value = string(raw)
+		} else {
+			value = strings.TrimLeft(value, " ")
+		}
+
+		if entry == nil {
+			if strings.ToLower(name) == "version" {
+				continue
+			}
+			if strings.ToLower(name) != "dn" {
+				return nil, fmt.Errorf("record does not start with a dn")
+			}
+			entry = &Entry{DN: value}
+			continue
+		}
+
+		if strings.ToLower(name) == "changetype" {
+			continue
+		}
+
+		found := false
+		for _, attr := range entry.Attributes {
+			if strings.EqualFold(attr.Name, name) {
+				attr.Values = append(attr.Values, value)
+				found = true
+				break
+			}
+		}
+		if !found {
+			entry.Attributes = append(entry.Attributes, &EntryAttribute{Name: name, Values: []string{value}})
+		}
+	}
+	return entry, nil
+}
@@ -0,0 +1,44 @@
+package ldap
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLDIF(t *testing.T) {
+	data := "version: 1\n" +
+		"# comment\n" +
+		"dn:\n" +
+		"vendorName: test\n" +
+		"\n" +
+		"dn: CN=svc,CN=Users,DC=corp,DC=local\r\n" +
+		"objectClass: top\n" +
+		"objectClass: user\n" +
+		"description:: c2VydmljZSBhY2NvdW50\n" +
+		"memberOf: CN=Domain Admins,CN=Users,\n" +
+		" DC=corp,DC=local\n"
+
+	entries, err := ParseLDIF(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("ParseLDIF failed: %s", err)
+	}
+
+	expected := []*Entry{
+		&Entry{DN: "", Attributes: []*EntryAttribute{
+			&EntryAttribute{Name: "vendorName", Values: []string{"test"}},
+		}},
+		&Entry{DN: "CN=svc,CN=Users,DC=corp,DC=local", Attributes: []*EntryAttribute{
+			&EntryAttribute{Name: "objectClass", Values: []string{"top", "user"}},
+			&EntryAttribute{Name: "description", Values: []string{"service account"}},
+			&EntryAttribute{Name: "memberOf", Values: []string{"CN=Domain Admins,CN=Users,DC=corp,DC=local"}},
+		}},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("unexpected entries: %#v", entries)
+	}
+
+	if _, err := ParseLDIF(strings.NewReader("objectClass: top\n")); err == nil {
+		t.Errorf("expected an error for a record without a dn")
+	}
+}
@@ -14,6 +14,9 @@ import (
 type Binder interface {
 	Bind(bindDN, bindSimplePw string, conn net.Conn) (LDAPResultCode, error)
 }
+type SASLBinder interface {
+	SASLBind(bindDN, mechanism string, credentials []byte, conn net.Conn) (LDAPResultCode, []byte, error)
+}
 type Searcher interface {
 	Search(boundDN string, req SearchRequest, conn net.Conn) (ServerSearchResult, error)
 }
@@ -61,6 +64,7 @@ type Server struct {
 	Quit        chan bool
 	EnforceLDAP bool
 	Stats       *Stats
+	TLSConfig   *tls.Config
 }
 
 type Stats struct {
@@ -289,7 +293,7 @@ handler:
 
 		case ApplicationBindRequest:
 			server.Stats.countBinds(1)
-			ldapResultCode := HandleBindRequest(req, server.BindFns, conn)
+			ldapResultCode, serverSaslCreds := HandleBindRequest(req, server.BindFns, conn)
 			if ldapResultCode == LDAPResultSuccess {
 				boundDN, ok = req.Children[1].Value.(string)
 				if !ok {
@@ -297,7 +301,7 @@ handler:
 					break handler
 				}
 			}
-			responsePacket := encodeBindResponse(messageID, ldapResultCode)
+			responsePacket := encodeBindResponse(messageID, ldapResultCode, serverSaslCreds)
 			if err = sendPacket(conn, responsePacket); err != nil {
 				// log.Printf("sendPacket error %s", err.Error())
 				break handler
@@ -322,6 +326,17 @@ handler:
 			server.Stats.countUnbinds(1)
 			break handler // simply disconnect
 		case ApplicationExtendedRequest:
+			if len(req.Children) > 0 && ber.DecodeString(req.Children[0].Data.Bytes()) == LDAPStartTLSOID {
+				tlsConn, err := server.startTLS(messageID, conn)
+				if err != nil {
+					// log.Printf("StartTLS error %s", err.Error())
+					break handler
+				}
+				if tlsConn != nil {
+					conn = tlsConn
+				}
+				break
+			}
 			ldapResultCode := HandleExtendedRequest(req, boundDN, server.ExtendedFns, conn)
 			responsePacket := encodeLDAPResponse(messageID, ApplicationExtendedResponse, ldapResultCode, LDAPResultCodeMap[ldapResultCode])
 			if err = sendPacket(conn, responsePacket); err != nil {
@@ -377,6 +392,32 @@ handler:
 	conn.Close()
 }
 
+// startTLS answers a StartTLS request and upgrades the connection when a TLS config is available
+func (server *Server) startTLS(messageID uint64, conn net.Conn) (net.Conn, error) {
+	if _, secure := conn.(*tls.Conn); secure || server.TLSConfig == nil {
+		responsePacket := encodeLDAPResponse(messageID, ApplicationExtendedResponse, LDAPResultOperationsError, "StartTLS is not available")
+		return nil, sendPacket(conn, responsePacket)
+	}
+
+	responsePacket := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	responsePacket.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ApplicationExtendedResponse, nil, ApplicationMap[ApplicationExtendedResponse])
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, uint64(LDAPResultSuccess), "resultCode: "))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN: "))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "errorMessage: "))
+	response.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 10, LDAPStartTLSOID, "responseName: "))
+	responsePacket.AppendChild(response)
+	if err := sendPacket(conn, responsePacket); err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Server(conn, server.TLSConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
 //
 func sendPacket(conn net.Conn, packet *ber.Packet) error {
 	_, err := conn.Write(packet.Bytes())
@@ -6,7 +6,7 @@ import (
 	ber "github.com/atredispartners/flamingo/pkg/asn1-ber"
 )
 
-func HandleBindRequest(req *ber.Packet, fns map[string]Binder, conn net.Conn) (resultCode LDAPResultCode) {
+func HandleBindRequest(req *ber.Packet, fns map[string]Binder, conn net.Conn) (resultCode LDAPResultCode, serverSaslCreds []byte) {
 	defer func() {
 		if r := recover(); r != nil {
 			resultCode = LDAPResultOperationsError
@@ -16,23 +16,23 @@ func HandleBindRequest(req *ber.Packet, fns map[string]Binder, conn net.Conn) (r
 	// we only support ldapv3
 	ldapVersion, ok := req.Children[0].Value.(uint64)
 	if !ok {
-		return LDAPResultProtocolError
+		return LDAPResultProtocolError, nil
 	}
 	if ldapVersion != 3 {
 		// log.Debugf("Unsupported LDAP version: %d", ldapVersion)
-		return LDAPResultInappropriateAuthentication
+		return LDAPResultInappropriateAuthentication, nil
 	}
 
 	// auth types
 	bindDN, ok := req.Children[1].Value.(string)
 	if !ok {
-		return LDAPResultProtocolError
+		return LDAPResultProtocolError, nil
 	}
 	bindAuth := req.Children[2]
 	switch bindAuth.Tag {
 	default:
 		// log.Debug("Unknown LDAP authentication method")
-		return LDAPResultInappropriateAuthentication
+		return LDAPResultInappropriateAuthentication, nil
 	case LDAPBindAuthSimple:
 		if len(req.Children) == 3 {
 			fnNames := []string{}
@@ -43,21 +43,45 @@ func HandleBindRequest(req *ber.Packet, fns map[string]Binder, conn net.Conn) (r
 			resultCode, err := fns[fn].Bind(bindDN, bindAuth.Data.String(), conn)
 			if err != nil {
 				// log.Debugf("BindFn Error %s", err.Error())
-				return LDAPResultOperationsError
+				return LDAPResultOperationsError, nil
 			}
-			return resultCode
+			return resultCode, nil
 		} else {
 			// log.Debug("Simple bind request has wrong # children.  len(req.Children) != 3")
-			return LDAPResultInappropriateAuthentication
+			return LDAPResultInappropriateAuthentication, nil
 		}
 	case LDAPBindAuthSASL:
-		// log.Debug("SASL authentication is not supported")
-		return LDAPResultInappropriateAuthentication
+		// SaslCredentials ::= SEQUENCE { mechanism LDAPString, credentials OCTET STRING OPTIONAL }
+		if len(bindAuth.Children) < 1 || len(bindAuth.Children) > 2 {
+			return LDAPResultProtocolError, nil
+		}
+		mechanism := bindAuth.Children[0].Data.String()
+		credentials := []byte{}
+		if len(bindAuth.Children) == 2 {
+			credentials = bindAuth.Children[1].Data.Bytes()
+		}
+
+		fnNames := []string{}
+		for k := range fns {
+			fnNames = append(fnNames, k)
+		}
+		fn := routeFunc(bindDN, fnNames)
+		saslFn, ok := fns[fn].(SASLBinder)
+		if !ok {
+			// log.Debug("SASL authentication is not supported")
+			return LDAPResultInappropriateAuthentication, nil
+		}
+		resultCode, serverSaslCreds, err := saslFn.SASLBind(bindDN, mechanism, credentials, conn)
+		if err != nil {
+			// log.Debugf("SASLBindFn Error %s", err.Error())
+			return LDAPResultOperationsError, nil
+		}
+		return resultCode, serverSaslCreds
 	}
-	return LDAPResultOperationsError
+	return LDAPResultOperationsError, nil
 }
 
-func encodeBindResponse(messageID uint64, ldapResultCode LDAPResultCode) *ber.Packet {
+func encodeBindResponse(messageID uint64, ldapResultCode LDAPResultCode, serverSaslCreds []byte) *ber.Packet {
 	responsePacket := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
 	responsePacket.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
 
@@ -65,6 +89,9 @@ func encodeBindResponse(messageID uint64, ldapResultCode LDAPResultCode) *ber.Pa
 	bindReponse.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, uint64(ldapResultCode), "resultCode: "))
 	bindReponse.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN: "))
 	bindReponse.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "errorMessage: "))
+	if serverSaslCreds != nil {
+		bindReponse.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 7, string(serverSaslCreds), "serverSaslCreds: "))
+	}
 
 	responsePacket.AppendChild(bindReponse)
 
@@ -155,6 +155,19 @@ func parseSearchRequest(boundDN string, req *ber.Packet, controls *[]Control) (S
 	return searchReq, nil
 }
 
+// operationalAttributes lists the operational attributes returned for a request for "+"
+var operationalAttributes = map[string]bool{
+	"createtimestamp":         true,
+	"currenttime":             true,
+	"modifytimestamp":         true,
+	"namingcontexts":          true,
+	"subschemasubentry":       true,
+	"supportedcontrol":        true,
+	"supportedextension":      true,
+	"supportedldapversion":    true,
+	"supportedsaslmechanisms": true,
+}
+
 /////////////////////////
 func filterAttributes(entry *Entry, attributes []string) (*Entry, error) {
 	// only return requested attributes
@@ -162,8 +175,9 @@ func filterAttributes(entry *Entry, attributes []string) (*Entry, error) {
 
 	for _, attr := range entry.Attributes {
 		for _, requested := range attributes {
-			if requested == "*" || strings.ToLower(attr.Name) == strings.ToLower(requested) {
+			if requested == "*" || (requested == "+" && operationalAttributes[strings.ToLower(attr.Name)]) || strings.ToLower(attr.Name) == strings.ToLower(requested) {
 				newAttributes = append(newAttributes, attr)
+				break
 			}
 		}
 	}
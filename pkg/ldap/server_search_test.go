@@ -503,3 +503,34 @@ func TestSearchControls(t *testing.T) {
 	}
 	quit <- true
 }
+
+func TestFilterAttributes(t *testing.T) {
+	tests := []struct {
+		requested []string
+		expected  []string
+	}{
+		{[]string{"cn"}, []string{"cn"}},
+		{[]string{"*"}, []string{"cn", "objectClass", "currentTime", "supportedExtension"}},
+		{[]string{"+"}, []string{"currentTime", "supportedExtension"}},
+		{[]string{"*", "cn"}, []string{"cn", "objectClass", "currentTime", "supportedExtension"}},
+		{[]string{"+", "CN"}, []string{"cn", "currentTime", "supportedExtension"}},
+	}
+
+	for _, tt := range tests {
+		entry := &Entry{DN: "cn=ned,o=testers,c=test", Attributes: []*EntryAttribute{
+			{"cn", []string{"ned"}},
+			{"objectClass", []string{"person"}},
+			{"currentTime", []string{"20240101000000.0Z"}},
+			{"supportedExtension", []string{"1.3.6.1.4.1.1466.20037"}},
+		}}
+		filtered, _ := filterAttributes(entry, tt.requested)
+
+		names := []string{}
+		for _, attr := range filtered.Attributes {
+			names = append(names, attr.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(tt.expected, ",") {
+			t.Errorf("filterAttributes %v returned %v, expected %v", tt.requested, names, tt.expected)
+		}
+	}
+}